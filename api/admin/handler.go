@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+	v1 "scope3apiproxy/api/v1"
+	"scope3apiproxy/internal/cache"
+	v2 "scope3apiproxy/internal/scope3/v2"
+)
+
+// AdminHandler exposes the internal state of the app (eg, circuit breaker) for operations.
+type AdminHandler struct {
+	logger          *zap.Logger
+	scope3APIClient *v2.Scope3APIClient
+	appCache        cache.Store
+	*http.ServeMux
+}
+
+func NewHandler(logger *zap.Logger, scope3APIClient *v2.Scope3APIClient, appCache cache.Store) http.Handler {
+	handler := &AdminHandler{logger, scope3APIClient, appCache, http.NewServeMux()}
+	handler.HandleFunc("/admin/scope3/circuit-breaker", handler.getCircuitBreaker)
+	handler.HandleFunc("/admin/scope3/queue", handler.getQueue)
+	handler.HandleFunc("/admin/scope3/api-keys", handler.getApiKeys)
+	handler.HandleFunc("/admin/cache/tiers", handler.getCacheTiers)
+	return handler
+}
+
+func (h *AdminHandler) getCircuitBreaker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respond(w, r, http.StatusMethodNotAllowed, v1.APIResult{Error: "Only GET method is allowed"})
+		return
+	}
+	h.respond(w, r, http.StatusOK, v1.APIResult{Data: h.scope3APIClient.CircuitBreaker().Stats()})
+}
+
+func (h *AdminHandler) getQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respond(w, r, http.StatusMethodNotAllowed, v1.APIResult{Error: "Only GET method is allowed"})
+		return
+	}
+	h.respond(w, r, http.StatusOK, v1.APIResult{Data: h.scope3APIClient.ConcurrencyLimiter().Stats()})
+}
+
+func (h *AdminHandler) getApiKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respond(w, r, http.StatusMethodNotAllowed, v1.APIResult{Error: "Only GET method is allowed"})
+		return
+	}
+	h.respond(w, r, http.StatusOK, v1.APIResult{Data: h.scope3APIClient.ApiKeyPool().Stats()})
+}
+
+func (h *AdminHandler) getCacheTiers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respond(w, r, http.StatusMethodNotAllowed, v1.APIResult{Error: "Only GET method is allowed"})
+		return
+	}
+	tieredStore, ok := h.appCache.(*cache.TieredStore)
+	if !ok {
+		h.respond(w, r, http.StatusNotFound, v1.APIResult{Error: "The cache has no tiers, see cache.backend config"})
+		return
+	}
+	h.respond(w, r, http.StatusOK, v1.APIResult{Data: tieredStore.Stats()})
+}
+
+func (h *AdminHandler) respond(
+	w http.ResponseWriter,
+	r *http.Request,
+	code int,
+	result v1.APIResult,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error(v1.GenericLogUnsentResponseError,
+			zap.Error(err),
+			zap.String(v1.LoggerKeyRequestMethod, r.Method),
+			zap.String(v1.LoggerKeyRequestUrl, r.URL.String()),
+		)
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	v1 "scope3apiproxy/api/v1"
+	"scope3apiproxy/internal/cache"
+	v2 "scope3apiproxy/internal/scope3/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	// The cached responses are gob encoded by the stores shared across replicas (eg, redis)
+	gob.Register(&v2.ForwardResponse{})
+}
+
+// PathPrefix is where the scope3 v2 endpoints are exposed, eg /scope3/v2/properties calls /v2/properties of scope3.
+const PathPrefix = "/scope3/v2"
+
+// CacheKeyPrefix keeps the cached responses apart from the other records of the cache (eg, emissions).
+const CacheKeyPrefix = "scope3/v2 "
+
+// CacheStatusHeader tells whether the response comes from the cache (HIT) or from scope3 server (MISS).
+const CacheStatusHeader = "X-Cache"
+
+// Route allows the calls to the scope3 v2 endpoints under Path. The calls matching no route are rejected, so that the
+// api key of the proxy is only used for the endpoints the proxy means to expose.
+type Route struct {
+	// Path is relative to PathPrefix (eg, /properties). It matches the path itself and any path below it.
+	Path string
+	// Methods are the allowed HTTP methods. Defaults to GET.
+	Methods []string
+	// Ttl is how long the HTTP 200 responses are cached. Not set means the responses are not cached.
+	Ttl time.Duration
+}
+
+type Config struct {
+	Routes []Route
+}
+
+// ProxyHandler passes the calls through to scope3 server with the api key of the proxy. GET responses are cached per
+// url, while the responses of the other methods (eg, POST) are cached per url and hash of the request body.
+type ProxyHandler struct {
+	logger          *zap.Logger
+	scope3APIClient *v2.Scope3APIClient
+	cache           cache.Store
+	routes          []Route
+	*http.ServeMux
+}
+
+func NewHandler(
+	logger *zap.Logger,
+	scope3APIClient *v2.Scope3APIClient,
+	appCache cache.Store,
+	config Config,
+) http.Handler {
+	routes := make([]Route, 0, len(config.Routes))
+	for _, route := range config.Routes {
+		route.Path = "/" + strings.Trim(route.Path, "/")
+		if len(route.Methods) == 0 {
+			route.Methods = []string{http.MethodGet}
+		}
+		routes = append(routes, route)
+	}
+	// The most specific route wins when several routes match the same path
+	slices.SortStableFunc(routes, func(a, b Route) int {
+		return len(b.Path) - len(a.Path)
+	})
+	handler := &ProxyHandler{logger, scope3APIClient, appCache, routes, http.NewServeMux()}
+	handler.HandleFunc(PathPrefix+"/", handler.forward)
+	return handler
+}
+
+func (h *ProxyHandler) forward(w http.ResponseWriter, r *http.Request) {
+	// The path is kept escaped, otherwise an escaped character (eg, %3F) would change the meaning of the url of scope3
+	path := strings.TrimPrefix(r.URL.EscapedPath(), PathPrefix)
+	route, exists := h.route(path)
+	if !exists || !isCleanPath(path) {
+		h.notOk(w, r, http.StatusForbidden, "Scope3 endpoint "+path+" is not allowed")
+		return
+	}
+	if !slices.Contains(route.Methods, r.Method) {
+		w.Header().Set("Allow", strings.Join(route.Methods, ", "))
+		h.notOk(w, r, http.StatusMethodNotAllowed, "Only "+strings.Join(route.Methods, ", ")+" methods are allowed")
+		return
+	}
+
+	defer r.Body.Close()
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.notOk(w, r, http.StatusBadRequest, "Unable to read the request body")
+		return
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	cacheKey := forwardCacheKey(r.Method, path, requestBody)
+	if route.Ttl > 0 {
+		cached, exists := h.cache.Get(cacheKey)
+		if forwarded, ok := cached.(*v2.ForwardResponse); exists && ok {
+			h.write(w, r, forwarded, "HIT")
+			return
+		}
+	}
+
+	resp, err := h.scope3APIClient.Forward(r.Context(), r.Method, path, requestBody)
+	if err != nil {
+		h.logger.Warn("Unable to forward the call to scope3 server",
+			zap.Error(err),
+			zap.String(v1.LoggerKeyRequestMethod, r.Method),
+			zap.String(v1.LoggerKeyRequestUrl, r.URL.String()),
+		)
+		h.notOkOnScope3Error(w, r, err)
+		return
+	}
+	if route.Ttl > 0 && resp.StatusCode == http.StatusOK {
+		h.cache.Set(cacheKey, resp, 0, route.Ttl)
+	}
+	h.write(w, r, resp, "MISS")
+}
+
+// route returns the most specific route matching the path.
+func (h *ProxyHandler) route(path string) (Route, bool) {
+	for _, route := range h.routes {
+		if path == route.Path || route.Path == "/" || strings.HasPrefix(path, route.Path+"/") {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// isCleanPath tells whether none of the segments of the escaped path is a dot segment, or holds an escaped separator,
+// once unescaped. Otherwise scope3 server could resolve the path to an endpoint matching no route.
+func isCleanPath(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil || unescaped == "." || unescaped == ".." || strings.ContainsAny(unescaped, "/\\") {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardCacheKey is the method and the url of the call, along with the hash of the request body if any.
+func forwardCacheKey(method string, path string, requestBody []byte) string {
+	key := CacheKeyPrefix + method + " " + path
+	if len(requestBody) > 0 {
+		hash := sha256.Sum256(requestBody)
+		key += " " + hex.EncodeToString(hash[:])
+	}
+	return key
+}
+
+func (h *ProxyHandler) write(w http.ResponseWriter, r *http.Request, resp *v2.ForwardResponse, cacheStatus string) {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	w.Header().Set(CacheStatusHeader, cacheStatus)
+	w.WriteHeader(resp.StatusCode)
+	if _, err := w.Write(resp.Body); err != nil {
+		h.logger.Error(v1.GenericLogUnsentResponseError,
+			zap.Error(err),
+			zap.String(v1.LoggerKeyRequestMethod, r.Method),
+			zap.String(v1.LoggerKeyRequestUrl, r.URL.String()),
+		)
+	}
+}
+
+// notOkOnScope3Error answers with the HTTP status matching the reason scope3 server couldn't be called. The responses of
+// scope3 server, even the failed ones, are passed through as is instead.
+func (h *ProxyHandler) notOkOnScope3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		rateLimitedError  v2.Scope3RateLimitedError
+		unauthorizedError v2.Scope3UnauthorizedError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		h.notOk(w, r, http.StatusGatewayTimeout, v1.Scope3TimeoutClientError)
+	case errors.As(err, &rateLimitedError):
+		if rateLimitedError.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimitedError.RetryAfter.Seconds()))))
+		}
+		h.notOk(w, r, http.StatusTooManyRequests, v1.Scope3RateLimitedClientError)
+	case errors.As(err, &unauthorizedError):
+		// Every api key of the proxy is rejected, which the client can't fix
+		h.notOk(w, r, http.StatusBadGateway, v1.GenericClientError)
+	default:
+		// Unreachable, circuit breaker open, or shed from the queue
+		h.notOk(w, r, http.StatusServiceUnavailable, v1.Scope3UnavailableClientError)
+	}
+}
+
+func (h *ProxyHandler) notOk(w http.ResponseWriter, r *http.Request, code int, errorMessage string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v1.APIResult{Error: errorMessage}); err != nil {
+		h.logger.Error(v1.GenericLogUnsentResponseError,
+			zap.Error(err),
+			zap.String(v1.LoggerKeyRequestMethod, r.Method),
+			zap.String(v1.LoggerKeyRequestUrl, r.URL.String()),
+		)
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"scope3apiproxy/internal/cache"
+	v2 "scope3apiproxy/internal/scope3/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForward(t *testing.T) {
+	routes := []Route{
+		{Path: "/properties", Ttl: 1 * time.Hour},
+		{Path: "/properties/search", Methods: []string{http.MethodPost}, Ttl: 1 * time.Hour},
+		{Path: "/uncached"},
+	}
+
+	t.Run("with cached GET", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusOK)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		for _, cacheStatus := range []string{"MISS", "HIT"} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/1?fields=name", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, cacheStatus, rr.Header().Get(CacheStatusHeader))
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			assert.JSONEq(t, `{"call":"GET /v2/properties/1?fields=name"}`, rr.Body.String())
+		}
+		assert.Equal(t, []string{"GET /v2/properties/1?fields=name"}, calls.all())
+
+		// Another query is another cache record
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/1?fields=id", nil))
+		assert.Equal(t, "MISS", rr.Header().Get(CacheStatusHeader))
+	})
+
+	t.Run("with cached POST", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusOK)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		for _, requestBody := range []string{`{"name":"nytimes"}`, `{"name":"nytimes"}`, `{"name":"cnn"}`} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/scope3/v2/properties/search",
+				bytes.NewBufferString(requestBody)))
+			assert.Equal(t, http.StatusOK, rr.Code)
+		}
+		assert.Equal(t, []string{
+			`POST /v2/properties/search {"name":"nytimes"}`,
+			`POST /v2/properties/search {"name":"cnn"}`,
+		}, calls.all())
+	})
+
+	t.Run("with uncached route", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusOK)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		for range 2 {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/uncached", nil))
+			assert.Equal(t, "MISS", rr.Header().Get(CacheStatusHeader))
+		}
+		assert.Len(t, calls.all(), 2)
+	})
+
+	t.Run("with failed response", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusNotFound)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		for range 2 {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/0", nil))
+			// Passed through as is but not cached
+			assert.Equal(t, http.StatusNotFound, rr.Code)
+			assert.Equal(t, "MISS", rr.Header().Get(CacheStatusHeader))
+		}
+		assert.Len(t, calls.all(), 2)
+	})
+
+	t.Run("with disallowed route or method", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusOK)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/measure", nil))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/propertiesX", nil))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/scope3/v2/properties/1", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+		assert.Equal(t, "GET", rr.Header().Get("Allow"))
+
+		// The most specific route wins
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/search", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+		assert.Empty(t, calls.all())
+	})
+
+	t.Run("with escaped path", func(t *testing.T) {
+		calls, scope3MockAPIServer := createMockHttpServerForProxy(t, http.StatusOK)
+		defer scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/1%3Ffields=name", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties/1?fields=name", nil))
+		// Forwarded as is, so that the escaped ? is never a query, nor the cache record of one
+		assert.Equal(t, "MISS", rr.Header().Get(CacheStatusHeader))
+		assert.Equal(t, []string{"GET /v2/properties/1%3Ffields=name", "GET /v2/properties/1?fields=name"}, calls.all())
+
+		for _, url := range []string{
+			"/scope3/v2/properties%2F..%2Fmeasure",
+			"/scope3/v2/properties/%2e%2e/measure",
+			"/scope3/v2/properties/..%2Fmeasure",
+			"/scope3/v2/properties/..%5Cmeasure",
+		} {
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+			assert.Equal(t, http.StatusForbidden, rr.Code, url)
+		}
+		assert.Len(t, calls.all(), 2)
+	})
+
+	t.Run("with scope3 unreachable", func(t *testing.T) {
+		scope3MockAPIServer := httptest.NewServer(http.NotFoundHandler())
+		scope3MockAPIServer.Close()
+		handler := createTestProxyHandler(scope3MockAPIServer.URL, routes)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scope3/v2/properties", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+}
+
+type forwardedCalls struct {
+	calls []string
+	mutex sync.Mutex
+}
+
+func (c *forwardedCalls) all() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// createMockHttpServerForProxy creates a scope3 API server mock that answers with the call it received, and verifies
+// that the api key of the proxy is used instead of the one of the client.
+func createMockHttpServerForProxy(t *testing.T, statusCode int) (*forwardedCalls, *httptest.Server) {
+	t.Helper()
+	calls := &forwardedCalls{}
+	return calls, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer proxy-api-key", r.Header.Get("Authorization"))
+		requestBody, _ := io.ReadAll(r.Body)
+		call := r.Method + " " + r.URL.String()
+		if len(requestBody) > 0 {
+			call += " " + string(requestBody)
+		}
+		calls.mutex.Lock()
+		calls.calls = append(calls.calls, call)
+		calls.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(`{"call":"` + r.Method + " " + r.URL.String() + `"}`))
+	}))
+}
+
+func createTestProxyHandler(mockServerHost string, routes []Route) http.Handler {
+	scope3APIClient := v2.NewScope3APIClient(v2.Scope3APIClientConfig{
+		Host:   mockServerHost,
+		ApiKey: "proxy-api-key",
+	})
+	appCache := cache.NewCache(cache.Config{Capacity: 10})
+	handler := NewHandler(zap.NewNop(), scope3APIClient, appCache, Config{Routes: routes})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The api key of the client is never forwarded
+		r.Header.Set("Authorization", "Bearer client-api-key")
+		handler.ServeHTTP(w, r)
+	})
+}
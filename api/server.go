@@ -4,38 +4,85 @@ import (
 	"context"
 	"errors"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
+	"scope3apiproxy/api/admin"
+	"scope3apiproxy/api/proxy"
 	v1 "scope3apiproxy/api/v1"
 	"scope3apiproxy/internal"
+	"scope3apiproxy/internal/cache"
+	v2 "scope3apiproxy/internal/scope3/v2"
 	"strconv"
 )
 
 type APIServer struct {
-	srv    *http.Server
-	logger *zap.Logger
+	srv *http.Server
+	// adminSrv serves the admin endpoints on their own port, so that they are not exposed along with the API. It is nil
+	// when the admin port is not set.
+	adminSrv *http.Server
+	logger   *zap.Logger
+	// cancelRequests cancels the context of the requests still in flight once the shutdown times out
+	cancelRequests context.CancelFunc
 }
 
-func NewAPIServer(port int, logger *zap.Logger, emissionService *internal.EmissionService) *APIServer {
-	handler := v1.NewHandler(logger, emissionService)
+func NewAPIServer(
+	port int,
+	adminPort int,
+	logger *zap.Logger,
+	emissionService *internal.EmissionService,
+	scope3APIClient *v2.Scope3APIClient,
+	appCache cache.Store,
+	proxyConfig proxy.Config,
+) *APIServer {
+	handler := http.NewServeMux()
+	handler.Handle("/api/v1/", v1.NewHandler(logger, emissionService))
+	handler.Handle(proxy.PathPrefix+"/", proxy.NewHandler(logger, scope3APIClient, appCache, proxyConfig))
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
 	srv := &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
 		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+	var adminSrv *http.Server
+	if adminPort > 0 {
+		adminSrv = &http.Server{
+			Addr:    ":" + strconv.Itoa(adminPort),
+			Handler: admin.NewHandler(logger, scope3APIClient, appCache),
+		}
 	}
 	return &APIServer{
-		srv:    srv,
-		logger: logger,
+		srv:            srv,
+		adminSrv:       adminSrv,
+		logger:         logger,
+		cancelRequests: cancelRequests,
 	}
 }
 
 func (s *APIServer) Run() {
-	s.logger.Info("HTTP server listening on " + s.srv.Addr)
-	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		s.logger.Error("Error starting HTTP server on "+s.srv.Addr, zap.Error(err))
+	if s.adminSrv != nil {
+		go s.listen(s.adminSrv)
+	}
+	s.listen(s.srv)
+}
+
+func (s *APIServer) listen(srv *http.Server) {
+	s.logger.Info("HTTP server listening on " + srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("Error starting HTTP server on "+srv.Addr, zap.Error(err))
 	}
 }
 
 func (s *APIServer) Shutdown(ctx context.Context, done chan bool) {
-	if err := s.srv.Shutdown(ctx); err != nil {
+	if s.adminSrv != nil {
+		// Only serves quick reads of the state of the app, so it is closed right away
+		s.adminSrv.Close()
+	}
+	err := s.srv.Shutdown(ctx)
+	// Requests still in flight once the shutdown timed out give up waiting for scope3 server
+	s.cancelRequests()
+	if err != nil {
 		s.logger.Error("HTTP APIServer shutdown exited with error", zap.Error(err))
 		done <- true
 	} else {
@@ -1,14 +1,32 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"net/http"
 	"scope3apiproxy/internal"
+	v2 "scope3apiproxy/internal/scope3/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// EmissionResponseFormatRows is the value of the format query param to answer with EmissionResponseRow for each
+// request row instead of EmissionPerProperty.
+const EmissionResponseFormatRows = "rows"
+
 type emissionRequestBody struct {
 	Rows []EmissionRequestBodyRow `json:"rows"`
+	// Fields are the fields of scope3 measure api to answer with (eg, totalEmissions). Defaults to emissionsBreakdown.
+	Fields []string `json:"fields,omitempty"`
+	// Latest measures the rows with the latest scope3 model. Defaults to true.
+	Latest *bool `json:"latest,omitempty"`
+	// Framework is the framework the emissions are broken down by. Defaults to the framework of scope3.
+	Framework string `json:"framework,omitempty"`
 }
 
 type EmissionRequestBodyRow struct {
@@ -20,6 +38,29 @@ type EmissionRequestBodyRow struct {
 	Priority    int    `json:"priority"`
 }
 
+// EmissionRequestTimeoutHeader is the optional header that sets how long the client is willing to wait for the
+// emissions, either as a duration (eg, 500ms) or in milliseconds. The request gives up waiting for scope3 server once
+// it is over.
+const EmissionRequestTimeoutHeader = "X-Request-Timeout"
+
+// EmissionLatencyBudgetHeader is the optional header that sets how long the request waits for scope3 server, in the
+// same format as EmissionRequestTimeoutHeader. The emissions not fetched by then are answered as pending instead of
+// failing the request, and they are cached for the next request once fetched.
+const EmissionLatencyBudgetHeader = "X-Latency-Budget"
+
+// EmissionPerProperty is the default response of the emissions API. Rows of the same property are collapsed into one entry.
+type EmissionPerProperty map[string]*v2.Breakdown
+
+// EmissionResponseRow is the emissions of a single request row. It echoes the row so clients can join it back to their
+// input, although the response rows are always in the same order as the request rows.
+type EmissionResponseRow struct {
+	EmissionRequestBodyRow
+	Emissions *v2.Breakdown `json:"emissions,omitempty"`
+	Stale     bool          `json:"stale,omitempty"`
+	Pending   bool          `json:"pending,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
 func (h *APIV1Handler) getEmissions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		h.notOk(w, r, http.StatusMethodNotAllowed, "Only POST method is allowed")
@@ -41,6 +82,21 @@ func (h *APIV1Handler) getEmissions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Any other field would leave the rows without emissions, which would be taken for an invalid response of scope3
+	for _, field := range requestBody.Fields {
+		if !slices.Contains(v2.SupportedMeasureFields, field) {
+			h.notOk(w, r, http.StatusBadRequest,
+				"Unsupported field "+field+", only "+strings.Join(v2.SupportedMeasureFields, ", ")+" are supported")
+			return
+		}
+	}
+
+	options := v2.MeasureOptions{
+		Fields:     requestBody.Fields,
+		Historical: requestBody.Latest != nil && !*requestBody.Latest,
+		Framework:  requestBody.Framework,
+	}
+
 	var filters []internal.EmissionFilter
 	for _, row := range requestBody.Rows {
 		filters = append(filters, internal.EmissionFilter{
@@ -50,14 +106,137 @@ func (h *APIV1Handler) getEmissions(w http.ResponseWriter, r *http.Request) {
 			Impressions: row.Impressions,
 			UtcDatetime: row.UtcDatetime,
 			Priority:    row.Priority,
+			Options:     options,
 		})
 	}
 
-	result, err := h.emissionService.GetEmissions(filters)
+	ctx := r.Context()
+	if timeoutHeader := r.Header.Get(EmissionRequestTimeoutHeader); timeoutHeader != "" {
+		timeout, ok := parseDurationHeader(timeoutHeader)
+		if !ok {
+			h.notOk(w, r, http.StatusBadRequest, "Invalid "+EmissionRequestTimeoutHeader+" header")
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var latencyBudget time.Duration
+	if latencyBudgetHeader := r.Header.Get(EmissionLatencyBudgetHeader); latencyBudgetHeader != "" {
+		var ok bool
+		latencyBudget, ok = parseDurationHeader(latencyBudgetHeader)
+		if !ok {
+			h.notOk(w, r, http.StatusBadRequest, "Invalid "+EmissionLatencyBudgetHeader+" header")
+			return
+		}
+	}
+
+	emissions, err := h.emissionService.GetEmissionsWithinBudget(ctx, filters, latencyBudget)
 	if err != nil {
-		h.notOk(w, r, http.StatusInternalServerError, GenericClientError)
+		h.notOkOnScope3Error(w, r, err)
 		h.logAppError("Unable to fetch emissions breakdown", r, &requestBodyInBytes, err)
 		return
 	}
-	h.ok(w, r, result)
+
+	// Rows that failed (eg, rejected by scope3) or are pending don't fail the other rows. Instead, the response is a
+	// mixed result, unless every row is pending.
+	code := http.StatusOK
+	pendingRows := 0
+	for _, emission := range emissions {
+		if emission.Pending {
+			pendingRows++
+		}
+		if emission.Error != "" || emission.Pending {
+			code = http.StatusMultiStatus
+		}
+	}
+	if pendingRows > 0 && pendingRows == len(emissions) {
+		code = http.StatusAccepted
+	}
+
+	if r.URL.Query().Get("format") == EmissionResponseFormatRows {
+		rows := make([]EmissionResponseRow, 0, len(emissions))
+		for i, emission := range emissions {
+			rows = append(rows, EmissionResponseRow{
+				EmissionRequestBodyRow: requestBody.Rows[i],
+				Emissions:              emission.Emissions,
+				Stale:                  emission.Stale,
+				Pending:                emission.Pending,
+				Error:                  emission.Error,
+			})
+		}
+		h.respond(w, r, code, APIResult{Data: rows})
+		return
+	}
+
+	result := EmissionPerProperty{}
+	rowErrors := map[string]string{}
+	var staleProperties, pendingProperties []string
+	for _, emission := range emissions {
+		if emission.Pending {
+			pendingProperties = append(pendingProperties, emission.Filter.InventoryId)
+		} else if emission.Error == "" {
+			result[emission.Filter.InventoryId] = emission.Emissions
+			if emission.Stale {
+				staleProperties = append(staleProperties, emission.Filter.InventoryId)
+			}
+		} else {
+			rowErrors[emission.Filter.InventoryId] = emission.Error
+		}
+	}
+	h.respond(w, r, code, APIResult{
+		Data:    result,
+		Errors:  rowErrors,
+		Stale:   staleProperties,
+		Pending: pendingProperties,
+	})
+}
+
+// parseDurationHeader parses a header holding a duration (eg, 500ms) or a number of milliseconds.
+func parseDurationHeader(value string) (time.Duration, bool) {
+	if milliseconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(milliseconds) * time.Millisecond, milliseconds > 0
+	}
+	timeout, err := time.ParseDuration(value)
+	return timeout, err == nil && timeout > 0
+}
+
+// notOkOnScope3Error answers with the HTTP status matching the error from scope3 server.
+func (h *APIV1Handler) notOkOnScope3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		serverError          v2.Scope3ServerError
+		rateLimitedError     v2.Scope3RateLimitedError
+		overloadedError      v2.Scope3OverloadedError
+		unauthorizedError    v2.Scope3UnauthorizedError
+		badRequestError      v2.Scope3BadRequestError
+		invalidResponseError v2.Scope3InvalidResponseError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		h.notOk(w, r, http.StatusGatewayTimeout, Scope3TimeoutClientError)
+	case errors.Is(err, context.Canceled):
+		// The client is gone, so nobody reads the response anyway
+		h.notOk(w, r, http.StatusServiceUnavailable, Scope3UnavailableClientError)
+	case errors.As(err, &rateLimitedError):
+		if rateLimitedError.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimitedError.RetryAfter.Seconds()))))
+		}
+		h.notOk(w, r, http.StatusTooManyRequests, Scope3RateLimitedClientError)
+	case errors.As(err, &serverError), errors.As(err, &overloadedError):
+		h.notOk(w, r, http.StatusServiceUnavailable, Scope3UnavailableClientError)
+	case errors.As(err, &badRequestError):
+		// The rows are invalid as a whole, so the client is the one that can fix it
+		// The response body of scope3 server is only logged, since it may tell about the internals of scope3 server
+		if badRequestError.Reason != "" {
+			h.notOk(w, r, http.StatusBadRequest, Scope3BadRequestClientError+": "+badRequestError.Reason)
+		} else {
+			h.notOk(w, r, http.StatusBadRequest, Scope3BadRequestClientError)
+		}
+	case errors.As(err, &unauthorizedError), errors.As(err, &invalidResponseError):
+		// The proxy is misconfigured (eg, api key) or scope3 server misbehaves, none of which the client can fix
+		h.notOk(w, r, http.StatusBadGateway, GenericClientError)
+	default:
+		h.notOk(w, r, http.StatusInternalServerError, GenericClientError)
+	}
 }
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -12,6 +13,8 @@ import (
 	"scope3apiproxy/internal/cache"
 	v2 "scope3apiproxy/internal/scope3/v2"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -22,7 +25,7 @@ import (
 //	 "rows": [
 //	   {
 //	     "emissionsBreakdown": {
-//	       "breakdown": <whatever json fields that comes here>
+//	       "breakdown": <emissions of each step of the ad supply chain>
 //	     },
 //	     "internal": {
 //	       "propertyName": "nytimes.com"
@@ -30,7 +33,11 @@ import (
 //	   }
 //	 ]
 //	}
-const dummyEmissionInEachProperties = `{"someproperty1":"somevalue1"}`
+const dummyEmissionInEachProperties = `{"adSelection":{"total":{"emissions":1.5},"breakdown":{"platform":{"emissions":1.5}}}}`
+
+// propertiesQueriedMutex guards the properties queried from the scope3 API server mocks since some of the queries
+// (eg, revalidation) are made in the background
+var propertiesQueriedMutex sync.Mutex
 
 func TestGetEmissions(t *testing.T) {
 	t.Run("with uncached property", func(t *testing.T) {
@@ -93,6 +100,703 @@ func TestGetEmissions(t *testing.T) {
 		verifyCache(t, appCache, propertyName)
 	})
 
+	t.Run("with cached property on different country, channel and date", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, appCache := createTestApiHandler(scope3MockAPIServer.URL, 2)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		usRow := EmissionRequestBodyRow{
+			Country:     "US",
+			Channel:     "web",
+			InventoryId: "nytimes.com",
+			Impressions: 1000,
+			UtcDatetime: "2024-10-31",
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, emissionRequestBody{Rows: []EmissionRequestBodyRow{usRow}}))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+
+		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
+		deRow := EmissionRequestBodyRow{
+			Country:     "DE",
+			Channel:     "ctv",
+			InventoryId: "nytimes.com",
+			Impressions: 1000,
+			UtcDatetime: "2025-01-05",
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, emissionRequestBody{Rows: []EmissionRequestBodyRow{deRow}}))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+
+		// Give a few moment for the cache to do its thing since caching is done in goroutine
+		time.Sleep(5 * time.Millisecond)
+		for _, row := range []EmissionRequestBodyRow{usRow, deRow} {
+			_, exists := appCache.Get(internal.DefaultEmissionCacheKey(internal.EmissionFilter{
+				Country:     row.Country,
+				Channel:     row.Channel,
+				InventoryId: row.InventoryId,
+				UtcDatetime: row.UtcDatetime,
+			}))
+			assert.True(t, exists, row.Country+" "+row.Channel+" "+row.UtcDatetime+" should be cached")
+		}
+	})
+
+	t.Run("with cached property on different impressions", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissionsPerImpression(t, propertiesQueriedFromScope3APIServer, 0.5)
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponseWithValue(t, rr, "nytimes.com", `{"adSelection":{"total":{"emissions":500}}}`)
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+
+		// Give a few moment for the cache to do its thing since caching is done in goroutine
+		time.Sleep(5 * time.Millisecond)
+		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
+		requestBody.Rows[0].Impressions = 1000000
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponseWithValue(t, rr, "nytimes.com", `{"adSelection":{"total":{"emissions":500000}}}`)
+		verifyNoScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer)
+	})
+
+	t.Run("with cached property on invalid impressions", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissionsPerImpression(t, propertiesQueriedFromScope3APIServer, 0.5)
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponseWithValue(t, rr, "nytimes.com", `{"adSelection":{"total":{"emissions":500}}}`)
+
+		time.Sleep(5 * time.Millisecond)
+		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
+		// Rejected whether the property is cached or not, instead of zero or negative emissions
+		requestBody.Rows = []EmissionRequestBodyRow{
+			{InventoryId: "nytimes.com", Impressions: 0, UtcDatetime: "2024-10-31"},
+			{InventoryId: "nytimes.com", Impressions: -1000, UtcDatetime: "2024-10-31"},
+			{InventoryId: "cnn.com", Impressions: 0, UtcDatetime: "2024-10-31"},
+		}
+		req := createTestHttpRequest(t, requestBody)
+		req.URL.RawQuery = "format=" + EmissionResponseFormatRows
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusMultiStatus, rr.Code)
+		var response struct {
+			Data []EmissionResponseRow `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Len(t, response.Data, 3)
+		for _, row := range response.Data {
+			assert.Nil(t, row.Emissions)
+			assert.Equal(t, internal.EmissionInvalidImpressionsError, row.Error)
+		}
+		verifyNoScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer)
+	})
+
+	t.Run("with cached property on different measure options", func(t *testing.T) {
+		var queries []string
+		var queriesMutex sync.Mutex
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queriesMutex.Lock()
+			queries = append(queries, r.URL.RawQuery)
+			queriesMutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("fields") == "totalEmissions" {
+				w.Write([]byte(`{"rows":[{"totalEmissions":2,"internal":{"propertyName":"nytimes.com"}}]}`))
+				return
+			}
+			w.Write([]byte(`{"rows":[{"emissionsBreakdown":{"breakdown":` + dummyEmissionInEachProperties +
+				`},"internal":{"propertyName":"nytimes.com"}}]}`))
+		}))
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 2)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		row := EmissionRequestBodyRow{InventoryId: "nytimes.com", Impressions: 1000, UtcDatetime: "2024-10-31"}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, emissionRequestBody{Rows: []EmissionRequestBodyRow{row}}))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+
+		// Give a few moment for the cache to do its thing since caching is done in goroutine
+		time.Sleep(5 * time.Millisecond)
+		latest := false
+		totalsRequestBody := emissionRequestBody{
+			Rows:   []EmissionRequestBodyRow{row},
+			Fields: []string{"totalEmissions"},
+			Latest: &latest,
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, totalsRequestBody))
+		verifyPerPropertyEmissionAppResponseWithValue(t, rr, "nytimes.com", `{"totals":{"total":{"emissions":2}}}`)
+
+		// Each view of the emissions is cached on its own
+		time.Sleep(5 * time.Millisecond)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, totalsRequestBody))
+		verifyPerPropertyEmissionAppResponseWithValue(t, rr, "nytimes.com", `{"totals":{"total":{"emissions":2}}}`)
+		assert.Equal(t, []string{
+			"includeRows=true&latest=true&fields=emissionsBreakdown",
+			"includeRows=true&latest=false&fields=totalEmissions",
+		}, queries)
+	})
+
+	t.Run("with rows format", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 3)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+				{
+					InventoryId: "foxnews.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+				{
+					Country:     "DE",
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2025-01-05",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequestWithTarget(t, "/?format="+EmissionResponseFormatRows, requestBody))
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com", "foxnews.com")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var apiResult struct {
+			Data []EmissionResponseRow `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+		assert.Equal(t, len(requestBody.Rows), len(apiResult.Data))
+		for i, row := range apiResult.Data {
+			assert.Equal(t, requestBody.Rows[i], row.EmissionRequestBodyRow)
+			assert.Equal(t, "", row.Error)
+			actualEmission, _ := json.Marshal(row.Emissions)
+			assert.JSONEq(t, dummyEmissionInEachProperties, string(actualEmission))
+		}
+	})
+
+	t.Run("with property rejected by scope3", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissionsWithRowErrors(t, propertiesQueriedFromScope3APIServer,
+			map[string]string{"unknown.com": "unknown inventory id"})
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, appCache := createTestApiHandler(scope3MockAPIServer.URL, 2)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "unknown.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "unknown.com", "nytimes.com")
+		verifyCache(t, appCache, "nytimes.com")
+		_, exists := appCache.Get(emissionCacheKey("unknown.com"))
+		assert.False(t, exists, "unknown.com should not be in cache")
+
+		assert.Equal(t, http.StatusMultiStatus, rr.Code)
+		var apiResult APIResult
+		_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+		assert.Equal(t, map[string]string{"unknown.com": "unknown inventory id"}, apiResult.Errors)
+		emissionPerProperty := apiResult.Data.(map[string]interface{})
+		actualEmission, _ := json.Marshal(emissionPerProperty["nytimes.com"])
+		assert.JSONEq(t, dummyEmissionInEachProperties, string(actualEmission))
+		assert.NotContains(t, emissionPerProperty, "unknown.com")
+	})
+
+	t.Run("with cached property past its soft TTL", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, appCache := createTestApiHandlerWithCacheTtl(scope3MockAPIServer.URL, 1, 1*time.Millisecond, 1*time.Hour)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+
+		// The cached emissions are served right away then refreshed in the background
+		time.Sleep(10 * time.Millisecond)
+		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+		time.Sleep(10 * time.Millisecond)
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+		verifyCache(t, appCache, "nytimes.com")
+	})
+
+	t.Run("with expired property while scope3 is down", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+
+		apiHandler, _ := createTestApiHandlerWithCacheTtl(scope3MockAPIServer.URL, 2, 1*time.Millisecond, 1*time.Millisecond)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+
+		// Let the cached emissions expire then make scope3 API server unreachable
+		time.Sleep(10 * time.Millisecond)
+		scope3MockAPIServer.Close()
+		requestBody.Rows = append(requestBody.Rows, EmissionRequestBodyRow{
+			InventoryId: "foxnews.com",
+			Impressions: 1000,
+			UtcDatetime: "2024-10-31",
+		})
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+
+		assert.Equal(t, http.StatusMultiStatus, rr.Code)
+		var apiResult APIResult
+		_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+		assert.Equal(t, []string{"nytimes.com"}, apiResult.Stale)
+		assert.Equal(t, map[string]string{"foxnews.com": internal.EmissionUnavailableError}, apiResult.Errors)
+		emissionPerProperty := apiResult.Data.(map[string]interface{})
+		actualEmission, _ := json.Marshal(emissionPerProperty["nytimes.com"])
+		assert.JSONEq(t, dummyEmissionInEachProperties, string(actualEmission))
+	})
+
+	t.Run("with stale property refreshed once scope3 recovers", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		var scope3Down atomic.Bool
+		scope3Down.Store(true)
+		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+		defer scope3MockAPIServer.Close()
+		flakyScope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope3Down.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			scope3MockAPIServer.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer flakyScope3MockAPIServer.Close()
+
+		apiHandler, appCache := createTestApiHandler(flakyScope3MockAPIServer.URL, 2)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+		var cachedEmissions v2.Breakdown
+		_ = json.Unmarshal([]byte(dummyEmissionInEachProperties), &cachedEmissions)
+		appCache.Set(emissionCacheKey("nytimes.com"), &cachedEmissions, 0, 1*time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		var apiResult APIResult
+		_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+		assert.Equal(t, []string{"nytimes.com"}, apiResult.Stale)
+
+		// Nothing is refreshed without interval
+		apiHandler.emissionService.RefreshStaleEmissions(context.Background(), 0)
+		_, exists := appCache.Get(emissionCacheKey("nytimes.com"))
+		assert.False(t, exists)
+
+		scope3Down.Store(false)
+		ctx, stopRefresh := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer stopRefresh()
+		apiHandler.emissionService.RefreshStaleEmissions(ctx, 10*time.Millisecond)
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "nytimes.com")
+		verifyCache(t, appCache, "nytimes.com")
+
+		// Served fresh from the cache afterward
+		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+		verifyNoScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer)
+	})
+
+	t.Run("with concurrent requests on the same uncached property", func(t *testing.T) {
+		var scope3APIServerCalls atomic.Int32
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope3APIServerCalls.Add(1)
+			// Slow enough for the concurrent requests to miss the cache at the same time
+			time.Sleep(50 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"rows":[{"emissionsBreakdown":{"breakdown":` + dummyEmissionInEachProperties +
+				`},"internal":{"propertyName":"nytimes.com"}}]}`))
+		}))
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+				verifyPerPropertyEmissionAppResponse(t, rr, "nytimes.com")
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), scope3APIServerCalls.Load())
+	})
+
+	t.Run("with concurrent requests on different uncached properties", func(t *testing.T) {
+		var scope3APIServerCalls atomic.Int32
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createCallCountingHttpServer(
+			t,
+			createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer),
+			&scope3APIServerCalls,
+		)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := v2.NewScope3APIClient(v2.Scope3APIClientConfig{Host: scope3MockAPIServer.URL})
+		measureBatcher := v2.NewMeasureBatcher(scope3APIClient, v2.MeasureBatcherConfig{Window: 50 * time.Millisecond})
+		apiHandler, appCache := createTestApiHandlerWithMeasureAPI(measureBatcher, 10)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		propertyNames := []string{"nytimes.com", "foxnews.com", "usatoday.com", "washingtonpost.com"}
+		var wg sync.WaitGroup
+		for _, propertyName := range propertyNames {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				requestBody := emissionRequestBody{
+					Rows: []EmissionRequestBodyRow{
+						{
+							InventoryId: propertyName,
+							Impressions: 1000,
+							UtcDatetime: "2024-10-31",
+						},
+					},
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+				verifyPerPropertyEmissionAppResponse(t, rr, propertyName)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), scope3APIServerCalls.Load())
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, propertyNames...)
+		verifyCache(t, appCache, propertyNames...)
+	})
+
+	t.Run("with more uncached properties than the max rows per request", func(t *testing.T) {
+		var scope3APIServerCalls atomic.Int32
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		scope3MockAPIServer := createCallCountingHttpServer(
+			t,
+			createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer),
+			&scope3APIServerCalls,
+		)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := v2.NewScope3APIClient(v2.Scope3APIClientConfig{
+			Host:                  scope3MockAPIServer.URL,
+			MaxRowsPerRequest:     2,
+			MaxConcurrentRequests: 2,
+		})
+		apiHandler, appCache := createTestApiHandlerWithMeasureAPI(scope3APIClient, 10)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+
+		propertyNames := []string{"nytimes.com", "foxnews.com", "usatoday.com", "washingtonpost.com", "cnn.com"}
+		var requestBody emissionRequestBody
+		for _, propertyName := range propertyNames {
+			requestBody.Rows = append(requestBody.Rows, EmissionRequestBodyRow{
+				InventoryId: propertyName,
+				Impressions: 1000,
+				UtcDatetime: "2024-10-31",
+			})
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+		verifyPerPropertyEmissionAppResponse(t, rr, propertyNames...)
+		assert.Equal(t, int32(3), scope3APIServerCalls.Load())
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, propertyNames...)
+		verifyCache(t, appCache, propertyNames...)
+	})
+
+	t.Run("with scope3 http errors", func(t *testing.T) {
+		testCases := []struct {
+			scope3Status       int
+			expectedStatus     int
+			expectedRetryAfter string
+		}{
+			{http.StatusServiceUnavailable, http.StatusServiceUnavailable, ""},
+			{http.StatusBadGateway, http.StatusServiceUnavailable, ""},
+			{http.StatusTooManyRequests, http.StatusTooManyRequests, "2"},
+			{http.StatusUnauthorized, http.StatusBadGateway, ""},
+			{http.StatusBadRequest, http.StatusBadRequest, ""},
+		}
+		for _, testCase := range testCases {
+			scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "2")
+				w.WriteHeader(testCase.scope3Status)
+			}))
+
+			apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+			handler := http.HandlerFunc(apiHandler.getEmissions)
+			requestBody := emissionRequestBody{
+				Rows: []EmissionRequestBodyRow{
+					{
+						InventoryId: "nytimes.com",
+						Impressions: 1000,
+						UtcDatetime: "2024-10-31",
+					},
+				},
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+			scope3MockAPIServer.Close()
+
+			assert.Equal(t, testCase.expectedStatus, rr.Code, "scope3 http status %d", testCase.scope3Status)
+			assert.Equal(t, testCase.expectedRetryAfter, rr.Header().Get("Retry-After"))
+			var apiResult APIResult
+			_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+			assert.NotEmpty(t, apiResult.Error)
+		}
+	})
+
+	t.Run("with scope3 bad request", func(t *testing.T) {
+		testCases := []struct {
+			scope3ResponseBody string
+			expectedError      string
+		}{
+			{`{"message":"Invalid utcDatetime","stack":"at measure (/app/measure.js:42)"}`, "Invalid request: Invalid utcDatetime"},
+			{`<html>Bad Request</html>`, "Invalid request"},
+		}
+		for _, testCase := range testCases {
+			scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(testCase.scope3ResponseBody))
+			}))
+
+			apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+			handler := http.HandlerFunc(apiHandler.getEmissions)
+			requestBody := emissionRequestBody{
+				Rows: []EmissionRequestBodyRow{{InventoryId: "nytimes.com", Impressions: 1000, UtcDatetime: "2024-10-31"}},
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createTestHttpRequest(t, requestBody))
+			scope3MockAPIServer.Close()
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			var apiResult APIResult
+			_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+			// Only the message of scope3 server is passed on, never its response body as is
+			assert.Equal(t, testCase.expectedError, apiResult.Error)
+		}
+	})
+
+	t.Run("with request timeout shorter than scope3 response time", func(t *testing.T) {
+		scope3RequestCancelled := make(chan struct{})
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The request context is only cancelled on disconnect once the body is read
+			_, _ = io.ReadAll(r.Body)
+			select {
+			case <-r.Context().Done():
+				close(scope3RequestCancelled)
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		req := createTestHttpRequest(t, requestBody)
+		req.Header.Set(EmissionRequestTimeoutHeader, "50ms")
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+		assert.Less(t, time.Since(start), 1*time.Second)
+		select {
+		case <-scope3RequestCancelled:
+		case <-time.After(1 * time.Second):
+			t.Error("Expected the call to scope3 server to be cancelled")
+		}
+	})
+
+	t.Run("with invalid request timeout", func(t *testing.T) {
+		apiHandler, _ := createTestApiHandler("http://localhost", 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+		req := createTestHttpRequest(t, emissionRequestBody{})
+		req.Header.Set(EmissionRequestTimeoutHeader, "soon")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("with unsupported fields", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+		}))
+		defer scope3MockAPIServer.Close()
+		apiHandler, _ := createTestApiHandler(scope3MockAPIServer.URL, 1)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+		req := createTestHttpRequest(t, emissionRequestBody{
+			Rows:   []EmissionRequestBodyRow{{InventoryId: "nytimes.com", Impressions: 1000, UtcDatetime: "2024-10-31"}},
+			Fields: []string{"totalEmissions", "inventoryCoverage"},
+		})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Unsupported field inventoryCoverage")
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("with latency budget shorter than scope3 response time", func(t *testing.T) {
+		propertiesQueriedFromScope3APIServer := make(map[string]bool)
+		fastScope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
+		defer fastScope3MockAPIServer.Close()
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			fastScope3MockAPIServer.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer scope3MockAPIServer.Close()
+
+		apiHandler, appCache := createTestApiHandler(scope3MockAPIServer.URL, 2)
+		handler := http.HandlerFunc(apiHandler.getEmissions)
+		var cachedEmissions v2.Breakdown
+		_ = json.Unmarshal([]byte(dummyEmissionInEachProperties), &cachedEmissions)
+		appCache.Set(emissionCacheKey("nytimes.com"), &cachedEmissions, 0, 1*time.Hour)
+
+		requestBody := emissionRequestBody{
+			Rows: []EmissionRequestBodyRow{
+				{
+					InventoryId: "nytimes.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+				{
+					InventoryId: "foxnews.com",
+					Impressions: 1000,
+					UtcDatetime: "2024-10-31",
+				},
+			},
+		}
+		req := createTestHttpRequest(t, requestBody)
+		req.Header.Set(EmissionLatencyBudgetHeader, "20")
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rr, req)
+
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+		assert.Equal(t, http.StatusMultiStatus, rr.Code)
+		var apiResult APIResult
+		_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+		data := apiResult.Data.(map[string]interface{})
+		assert.Contains(t, data, "nytimes.com")
+		assert.NotContains(t, data, "foxnews.com")
+		assert.Equal(t, []string{"foxnews.com"}, apiResult.Pending)
+
+		// The pending property is still fetched in the background for the next request
+		time.Sleep(300 * time.Millisecond)
+		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "foxnews.com")
+		verifyCache(t, appCache, "foxnews.com")
+	})
+
 	t.Run("with 1 cached property + 1 uncached property", func(t *testing.T) {
 		propertiesQueriedFromScope3APIServer := make(map[string]bool)
 		scope3MockAPIServer := createMockHttpServerForEmissions(t, propertiesQueriedFromScope3APIServer)
@@ -200,7 +904,7 @@ func TestGetEmissions(t *testing.T) {
 		verifyPerPropertyEmissionAppResponse(t, rr, "usatoday.com")
 		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "usatoday.com")
 		verifyCache(t, appCache, "nytimes.com", "usatoday.com")
-		_, exists := appCache.Get("foxnews.com" + internal.EmissionCacheKeySuffix)
+		_, exists := appCache.Get(emissionCacheKey("foxnews.com"))
 		assert.False(t, exists, "foxnews.com should not be in cache")
 	})
 
@@ -250,7 +954,7 @@ func TestGetEmissions(t *testing.T) {
 		verifyPerPropertyEmissionAppResponse(t, rr, "usatoday.com")
 		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "usatoday.com")
 		verifyCache(t, appCache, "nytimes.com", "usatoday.com")
-		_, exists := appCache.Get("foxnews.com" + internal.EmissionCacheKeySuffix)
+		_, exists := appCache.Get(emissionCacheKey("foxnews.com"))
 		assert.False(t, exists, "foxnews.com should not be in cache")
 
 		clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer)
@@ -269,9 +973,9 @@ func TestGetEmissions(t *testing.T) {
 		verifyPerPropertyEmissionAppResponse(t, rr, "washingtonpost.com")
 		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "washingtonpost.com")
 		verifyCache(t, appCache, "usatoday.com", "washingtonpost.com")
-		_, exists = appCache.Get("nytimes.com" + internal.EmissionCacheKeySuffix)
+		_, exists = appCache.Get(emissionCacheKey("nytimes.com"))
 		assert.False(t, exists, "nytimes.com should not be in cache")
-		_, exists = appCache.Get("foxnews.com" + internal.EmissionCacheKeySuffix)
+		_, exists = appCache.Get(emissionCacheKey("foxnews.com"))
 		assert.False(t, exists, "foxnews.com should not be in cache")
 	})
 
@@ -340,12 +1044,23 @@ func TestGetEmissions(t *testing.T) {
 		verifyPerPropertyEmissionAppResponse(t, rr, "usatoday.com")
 		verifyScope3APIServerCalls(t, propertiesQueriedFromScope3APIServer, "usatoday.com")
 		verifyCache(t, appCache, "usatoday.com", "foxnews.com")
-		_, exists := appCache.Get("nytimes.com" + internal.EmissionCacheKeySuffix)
+		_, exists := appCache.Get(emissionCacheKey("nytimes.com"))
 		assert.False(t, exists, "nytimes.com should not be in cache")
 	})
 }
 
+// emissionCacheKey builds the cache key of the given property queried without country and channel on 2024-10-31,
+// which is how most of the test scenarios query the emissions
+func emissionCacheKey(propertyName string) string {
+	return internal.DefaultEmissionCacheKey(internal.EmissionFilter{
+		InventoryId: propertyName,
+		UtcDatetime: "2024-10-31",
+	})
+}
+
 func clearPropertiesQueriedFromScope3APIServerMap(propertiesQueriedFromScope3APIServer map[string]bool) {
+	propertiesQueriedMutex.Lock()
+	defer propertiesQueriedMutex.Unlock()
 	for key := range propertiesQueriedFromScope3APIServer {
 		delete(propertiesQueriedFromScope3APIServer, key)
 	}
@@ -355,13 +1070,15 @@ func verifyCache(t *testing.T, appCache *cache.Cache, propertyNames ...string) {
 	// Give a few moment for the cache to do its thing since caching is done in goroutine
 	time.Sleep(5 * time.Millisecond)
 	for _, propertyName := range propertyNames {
-		_, exists := appCache.Get(propertyName + internal.EmissionCacheKeySuffix)
+		_, exists := appCache.Get(emissionCacheKey(propertyName))
 		assert.True(t, exists, propertyName+" should be cached")
 	}
 }
 
 func verifyScope3APIServerCalls(t *testing.T, propertiesQueriedFromScope3APIServer map[string]bool, propertyNames ...string) {
 	t.Helper()
+	propertiesQueriedMutex.Lock()
+	defer propertiesQueriedMutex.Unlock()
 	assert.Equal(t, len(propertyNames), len(propertiesQueriedFromScope3APIServer))
 	for _, propertyName := range propertyNames {
 		assert.True(t, propertiesQueriedFromScope3APIServer[propertyName])
@@ -370,6 +1087,8 @@ func verifyScope3APIServerCalls(t *testing.T, propertiesQueriedFromScope3APIServ
 
 func verifyNoScope3APIServerCalls(t *testing.T, propertiesQueriedFromScope3APIServer map[string]bool) {
 	t.Helper()
+	propertiesQueriedMutex.Lock()
+	defer propertiesQueriedMutex.Unlock()
 	assert.Equal(t, 0, len(propertiesQueriedFromScope3APIServer))
 }
 
@@ -382,11 +1101,27 @@ func verifyPerPropertyEmissionAppResponse(t *testing.T, rr *httptest.ResponseRec
 	emissionPerProperty := apiResult.Data.(map[string]interface{})
 	for _, propertyName := range propertyNames {
 		actualEmission, _ := json.Marshal(emissionPerProperty[propertyName])
-		assert.Equal(t, dummyEmissionInEachProperties, string(actualEmission))
+		assert.JSONEq(t, dummyEmissionInEachProperties, string(actualEmission))
 	}
 }
 
+func verifyPerPropertyEmissionAppResponseWithValue(t *testing.T, rr *httptest.ResponseRecorder, propertyName string, expectedEmission string) {
+	t.Helper()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var apiResult APIResult
+	_ = json.Unmarshal(rr.Body.Bytes(), &apiResult)
+	assert.Equal(t, "", apiResult.Error)
+	emissionPerProperty := apiResult.Data.(map[string]interface{})
+	actualEmission, _ := json.Marshal(emissionPerProperty[propertyName])
+	assert.Equal(t, expectedEmission, string(actualEmission))
+}
+
 func createTestHttpRequest(t *testing.T, requestBody interface{}) *http.Request {
+	t.Helper()
+	return createTestHttpRequestWithTarget(t, "/", requestBody)
+}
+
+func createTestHttpRequestWithTarget(t *testing.T, target string, requestBody interface{}) *http.Request {
 	t.Helper()
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(requestBody)
@@ -394,7 +1129,7 @@ func createTestHttpRequest(t *testing.T, requestBody interface{}) *http.Request
 		t.Fatal("Unable to encode request body")
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "/", &buf)
+	req, err := http.NewRequest(http.MethodPost, target, &buf)
 	if err != nil {
 		t.Fatalf("could not create request: %v", err)
 	}
@@ -402,6 +1137,17 @@ func createTestHttpRequest(t *testing.T, requestBody interface{}) *http.Request
 }
 
 func createMockHttpServerForEmissions(t *testing.T, propertiesQueriedFromScope3APIServer map[string]bool) *httptest.Server {
+	t.Helper()
+	return createMockHttpServerForEmissionsWithRowErrors(t, propertiesQueriedFromScope3APIServer, nil)
+}
+
+// createMockHttpServerForEmissionsWithRowErrors creates a scope3 API server mock that rejects the rows of the properties
+// in rowErrors with the mapped error message, the same way scope3 API server rejects invalid rows with HTTP 200.
+func createMockHttpServerForEmissionsWithRowErrors(
+	t *testing.T,
+	propertiesQueriedFromScope3APIServer map[string]bool,
+	rowErrors map[string]string,
+) *httptest.Server {
 	t.Helper()
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Verify the GetEmissionsBreakdown in internal.scope3.v2.measure.go calls the
@@ -420,8 +1166,14 @@ func createMockHttpServerForEmissions(t *testing.T, propertiesQueriedFromScope3A
 			// Should be the same fields with MeasureFilterRow
 			rowMap := row.(map[string]interface{})
 			propertyName := rowMap["inventoryId"].(string)
-			responseBodyRows = append(responseBodyRows, `{"emissionsBreakdown":{"breakdown":`+dummyEmissionInEachProperties+`},"internal":{"propertyName":"`+propertyName+`"}}`)
+			if rowError, rejected := rowErrors[propertyName]; rejected {
+				responseBodyRows = append(responseBodyRows, `{"error":{"message":"`+rowError+`"}}`)
+			} else {
+				responseBodyRows = append(responseBodyRows, `{"emissionsBreakdown":{"breakdown":`+dummyEmissionInEachProperties+`},"internal":{"propertyName":"`+propertyName+`"}}`)
+			}
+			propertiesQueriedMutex.Lock()
 			propertiesQueriedFromScope3APIServer[propertyName] = true
+			propertiesQueriedMutex.Unlock()
 		}
 		// Return the response as json
 		w.Header().Set("Content-Type", "application/json")
@@ -429,12 +1181,66 @@ func createMockHttpServerForEmissions(t *testing.T, propertiesQueriedFromScope3A
 	}))
 }
 
+// createMockHttpServerForEmissionsPerImpression creates a scope3 API server mock whose emissions are proportional to the
+// impressions of each row, the same way the real scope3 API server behaves.
+func createMockHttpServerForEmissionsPerImpression(
+	t *testing.T,
+	propertiesQueriedFromScope3APIServer map[string]bool,
+	emissionPerImpression float64,
+) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var requestBody struct {
+			Rows []v2.MeasureFilterRow `json:"rows"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&requestBody)
+		var responseBodyRows []string
+		for _, row := range requestBody.Rows {
+			emissions, _ := json.Marshal(emissionPerImpression * float64(row.Impressions))
+			responseBodyRows = append(responseBodyRows, `{"emissionsBreakdown":{"breakdown":{"adSelection":{"total":{"emissions":`+
+				string(emissions)+`}}}},"internal":{"propertyName":"`+row.InventoryId+`"}}`)
+			propertiesQueriedMutex.Lock()
+			propertiesQueriedFromScope3APIServer[row.InventoryId] = true
+			propertiesQueriedMutex.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"rows":[` + strings.Join(responseBodyRows, ",") + `]}`))
+	}))
+}
+
+// createCallCountingHttpServer wraps the handler of the given server to count how many calls it receives. The given
+// server is closed at the end of the test.
+func createCallCountingHttpServer(t *testing.T, server *httptest.Server, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	t.Cleanup(server.Close)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+}
+
+func createTestApiHandlerWithMeasureAPI(measureAPI v2.MeasureAPI, cacheCapacity int) (*APIV1Handler, *cache.Cache) {
+	appCache := cache.NewCache(cache.Config{Capacity: cacheCapacity, GracePeriod: 1 * time.Hour})
+	emissionService := internal.NewEmissionService(zap.NewNop(), measureAPI, appCache, 1*time.Hour, 1*time.Hour)
+	return &APIV1Handler{zap.NewNop(), emissionService, http.NewServeMux()}, appCache
+}
+
 func createTestApiHandler(mockServerHost string, cacheCapacity int) (*APIV1Handler, *cache.Cache) {
+	return createTestApiHandlerWithCacheTtl(mockServerHost, cacheCapacity, 1*time.Hour, 1*time.Hour)
+}
+
+func createTestApiHandlerWithCacheTtl(
+	mockServerHost string,
+	cacheCapacity int,
+	cacheSoftTtl time.Duration,
+	cacheTtl time.Duration,
+) (*APIV1Handler, *cache.Cache) {
 	logger := zap.NewNop()
 	scope3APIClient := v2.NewScope3APIClient(v2.Scope3APIClientConfig{
 		Host: mockServerHost,
 	})
-	appCache := cache.NewCache(cacheCapacity)
-	emissionService := internal.NewEmissionService(logger, scope3APIClient, appCache, 1*time.Hour)
+	appCache := cache.NewCache(cache.Config{Capacity: cacheCapacity, GracePeriod: 1 * time.Hour})
+	emissionService := internal.NewEmissionService(logger, scope3APIClient, appCache, cacheTtl, cacheSoftTtl)
 	return &APIV1Handler{zap.NewNop(), emissionService, http.NewServeMux()}, appCache
 }
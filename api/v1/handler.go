@@ -8,6 +8,10 @@ import (
 )
 
 const GenericClientError = "Something went wrong. Please try again later or contact scope3 team."
+const Scope3UnavailableClientError = "Scope3 is temporarily unavailable. Please try again later."
+const Scope3TimeoutClientError = "Scope3 did not answer in time. Please try again later."
+const Scope3RateLimitedClientError = "Too many requests to Scope3. Please try again later."
+const Scope3BadRequestClientError = "Invalid request"
 const GenericLogUnsentResponseError = "Unable to send the response payload to customers"
 const LoggerKeyRequestMethod = "requestMethod"
 const LoggerKeyRequestUrl = "requestUrl"
@@ -24,32 +28,24 @@ func NewHandler(logger *zap.Logger, emissionService *internal.EmissionService) h
 	return handler
 }
 
-func (h *APIV1Handler) ok(
+func (h *APIV1Handler) notOk(
 	w http.ResponseWriter,
 	r *http.Request,
-	result interface{},
+	code int,
+	errorMessage string,
 ) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(APIResult{Data: result}); err != nil {
-		// Less likely to happen, but it is best to handle errors even in extreme cases.
-		// In this case, we log the error with details for observability
-		h.logger.Error(GenericLogUnsentResponseError,
-			zap.Error(err),
-			zap.String(LoggerKeyRequestMethod, r.Method),
-			zap.String(LoggerKeyRequestUrl, r.URL.String()),
-		)
-	}
+	h.respond(w, r, code, APIResult{Error: errorMessage})
 }
 
-func (h *APIV1Handler) notOk(
+func (h *APIV1Handler) respond(
 	w http.ResponseWriter,
 	r *http.Request,
 	code int,
-	errorMessage string,
+	result APIResult,
 ) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(APIResult{Error: errorMessage}); err != nil {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		// Less likely to happen, but it is best to handle errors even in extreme cases.
 		// In this case, we log the error with details for observability
 		h.logger.Error(GenericLogUnsentResponseError,
@@ -80,4 +76,10 @@ func (h *APIV1Handler) logAppError(
 type APIResult struct {
 	Error string      `json:"error,omitempty"`
 	Data  interface{} `json:"data,omitempty"`
+	// Errors is the error per item (eg, per property) when only some of the items in Data failed
+	Errors map[string]string `json:"errors,omitempty"`
+	// Stale are the items in Data that are served from expired cache because scope3 server is unavailable
+	Stale []string `json:"stale,omitempty"`
+	// Pending are the items missing from Data because scope3 server didn't answer within the latency budget
+	Pending []string `json:"pending,omitempty"`
 }
@@ -11,59 +11,227 @@ type Record struct {
 	Value     interface{}
 	Priority  int
 	Frequency int
-	TTL       time.Time
-	Index     int // Index in the priority queue
+	// SoftTTL is when the record should be refreshed. It is still served until TTL though.
+	SoftTTL time.Time
+	TTL     time.Time
+	Index   int // Index in the priority queue
+	// ExpiryIndex is the index in the expiry queue
+	ExpiryIndex int
 }
 
+// Cache is split into shards by hash of the key, each with its own records, priority queue and lock, so that concurrent
+// lookups of different keys don't wait for each other. Each shard evicts on its own once its share of the capacity is
+// reached, which keeps the eviction order globally approximate since the keys are spread evenly across the shards.
 type Cache struct {
+	Capacity int
+	// GracePeriod is how long a record is kept after its TTL so that it can still be served as stale through GetStale
+	GracePeriod time.Duration
+	shards      []*shard
+	reaper      *reaper
+}
+
+type shard struct {
 	Capacity int
 	Record   map[string]*Record
 	Heap     *PriorityQueue
+	// Expiries are the records by TTL, so that the expired records are evicted before the live ones
+	Expiries *ExpiryQueue
 	Mutex    sync.Mutex
 }
 
+type Config struct {
+	Capacity    int
+	GracePeriod time.Duration
+	// Shards is how many shards the cache is split into. There are never more shards than the capacity. Defaults to 1.
+	Shards int
+	Reaper ReaperConfig
+}
+
 type PriorityQueue []*Record
 
-func NewCache(capacity int) *Cache {
-	pq := &PriorityQueue{}
-	heap.Init(pq)
-	return &Cache{
-		Capacity: capacity,
-		Record:   make(map[string]*Record),
-		Heap:     pq,
+// ExpiryQueue is the records ordered by TTL, where the first one expires first.
+type ExpiryQueue []*Record
+
+func NewCache(config Config) *Cache {
+	shardCount := max(min(config.Shards, config.Capacity), 1)
+	shards := make([]*shard, shardCount)
+	for i := range shards {
+		pq := &PriorityQueue{}
+		heap.Init(pq)
+		eq := &ExpiryQueue{}
+		heap.Init(eq)
+		shards[i] = &shard{
+			// The capacity is spread across the shards, where the first shards get the remainder
+			Capacity: config.Capacity / shardCount,
+			Record:   make(map[string]*Record),
+			Heap:     pq,
+			Expiries: eq,
+		}
+		if i < config.Capacity%shardCount {
+			shards[i].Capacity++
+		}
 	}
+	cache := &Cache{
+		Capacity:    config.Capacity,
+		GracePeriod: config.GracePeriod,
+		shards:      shards,
+	}
+	cache.reaper = newReaper(cache, config.Reaper)
+	return cache
 }
 
+// Get returns the value of the record only if it has not expired yet.
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.Mutex.Lock()
-	defer c.Mutex.Unlock()
+	value, _, exists := c.GetWithRevalidation(key)
+	return value, exists
+}
 
-	record, exists := c.Record[key]
+// GetWithRevalidation returns the value of the record only if it has not expired yet, same as Get.
+// revalidate is true when the soft TTL of the record has passed, meaning the value should be refreshed.
+func (c *Cache) GetWithRevalidation(key string) (value interface{}, revalidate bool, exists bool) {
+	s := c.shardOf(key)
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	record, exists := s.Record[key]
 	if !exists {
-		return nil, false
+		return nil, false, false
 	}
 
-	if time.Now().After(record.TTL) {
-		c.evict(key)
-		return nil, false
+	now := time.Now()
+	if now.After(record.TTL) {
+		// Expired records are kept within the grace period so that GetStale can still serve them
+		if now.After(record.TTL.Add(c.GracePeriod)) {
+			s.evict(key)
+		}
+		return nil, false, false
 	}
 
 	record.Frequency++
-	heap.Fix(c.Heap, record.Index)
-	return record.Value, true
+	heap.Fix(s.Heap, record.Index)
+	return record.Value, now.After(record.SoftTTL), true
+}
+
+// GetStale returns the value of the record even if it has expired, as long as it is still within the grace period.
+// stale is true when the record has expired.
+func (c *Cache) GetStale(key string) (value interface{}, stale bool, exists bool) {
+	s := c.shardOf(key)
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	record, exists := s.Record[key]
+	if !exists {
+		return nil, false, false
+	}
+
+	now := time.Now()
+	if now.After(record.TTL.Add(c.GracePeriod)) {
+		s.evict(key)
+		return nil, false, false
+	}
+	return record.Value, now.After(record.TTL), true
+}
+
+func (c *Cache) GetRecord(key string) (Record, bool) {
+	s := c.shardOf(key)
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	record, exists := s.Record[key]
+	if !exists {
+		return Record{}, false
+	}
+	if time.Now().After(record.TTL.Add(c.GracePeriod)) {
+		s.evict(key)
+		return Record{}, false
+	}
+	return *record, true
+}
+
+func (c *Cache) GetRecords(keys []string) map[string]Record {
+	records := make(map[string]Record, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		s := c.shardOf(key)
+		s.Mutex.Lock()
+		if record, exists := s.Record[key]; exists {
+			switch {
+			case now.After(record.TTL.Add(c.GracePeriod)):
+				s.evict(key)
+			case now.After(record.TTL):
+				records[key] = *record
+			default:
+				record.Frequency++
+				heap.Fix(s.Heap, record.Index)
+				records[key] = *record
+			}
+		}
+		s.Mutex.Unlock()
+	}
+	return records
 }
 
 func (c *Cache) Set(key string, value interface{}, priority int, ttl time.Duration) {
-	c.Mutex.Lock()
-	defer c.Mutex.Unlock()
+	c.SetWithSoftTTL(key, value, priority, ttl, ttl)
+}
+
+// SetWithSoftTTL caches the value until ttl. Once softTtl passes, GetWithRevalidation still returns the value but flags
+// it to be refreshed.
+func (c *Cache) SetWithSoftTTL(key string, value interface{}, priority int, softTtl time.Duration, ttl time.Duration) {
+	now := time.Now()
+	c.shardOf(key).set(key, value, priority, now.Add(softTtl), now.Add(ttl))
+}
 
-	if record, exists := c.Record[key]; exists {
+func (c *Cache) SetRecords(records []Record) {
+	for _, record := range records {
+		c.shardOf(record.Key).set(record.Key, record.Value, record.Priority, record.SoftTTL, record.TTL)
+	}
+}
+
+func (c *Cache) Evict(key string) {
+	s := c.shardOf(key)
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.evict(key)
+}
+
+// Len returns how many records are in the cache, including the expired ones still within the grace period.
+func (c *Cache) Len() int {
+	length := 0
+	for _, s := range c.shards {
+		s.Mutex.Lock()
+		length += len(s.Record)
+		s.Mutex.Unlock()
+	}
+	return length
+}
+
+func (c *Cache) shardOf(key string) *shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	// FNV-1a, inlined so that the lookups don't allocate
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return c.shards[hash%uint32(len(c.shards))]
+}
+
+func (s *shard) set(key string, value interface{}, priority int, softTtl time.Time, ttl time.Time) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if record, exists := s.Record[key]; exists {
 		// Update existing record.
 		record.Value = value
 		record.Priority = priority
-		record.TTL = time.Now().Add(ttl)
+		record.SoftTTL = softTtl
+		record.TTL = ttl
 		record.Frequency++
-		heap.Fix(c.Heap, record.Index)
+		heap.Fix(s.Heap, record.Index)
+		heap.Fix(s.Expiries, record.ExpiryIndex)
 	} else {
 		// Add new record.
 		record = &Record{
@@ -71,33 +239,43 @@ func (c *Cache) Set(key string, value interface{}, priority int, ttl time.Durati
 			Value:     value,
 			Priority:  priority,
 			Frequency: 1,
-			TTL:       time.Now().Add(ttl),
+			SoftTTL:   softTtl,
+			TTL:       ttl,
 		}
-		c.evictIfNeeded()
-		heap.Push(c.Heap, record)
-		c.Record[key] = record
+		s.evictIfNeeded()
+		s.push(record)
 	}
 }
 
-func (c *Cache) Evict(key string) {
-	c.Mutex.Lock()
-	defer c.Mutex.Unlock()
-	record := c.Record[key]
-	heap.Remove(c.Heap, record.Index)
-	delete(c.Record, key)
+func (s *shard) push(record *Record) {
+	heap.Push(s.Heap, record)
+	heap.Push(s.Expiries, record)
+	s.Record[record.Key] = record
+}
+
+// evictIfNeeded makes room for a new record once the shard is full.
+func (s *shard) evictIfNeeded() {
+	s.evictDownTo(s.Capacity - 1)
 }
 
-func (c *Cache) evictIfNeeded() {
-	for len(c.Record) >= c.Capacity {
-		record := heap.Pop(c.Heap).(*Record)
-		delete(c.Record, record.Key)
+// evictDownTo evicts the records until the shard has no more than the given number of records. The records past their
+// TTL are evicted first, since they are only kept to be served as stale, then the ones first in the priority queue.
+func (s *shard) evictDownTo(size int) {
+	now := time.Now()
+	for len(s.Record) > max(size, 0) {
+		if first := (*s.Expiries)[0]; now.After(first.TTL) {
+			s.evict(first.Key)
+			continue
+		}
+		s.evict((*s.Heap)[0].Key)
 	}
 }
 
-func (c *Cache) evict(key string) {
-	record := c.Record[key]
-	heap.Remove(c.Heap, record.Index)
-	delete(c.Record, key)
+func (s *shard) evict(key string) {
+	record := s.Record[key]
+	heap.Remove(s.Heap, record.Index)
+	heap.Remove(s.Expiries, record.ExpiryIndex)
+	delete(s.Record, key)
 }
 
 func (pq *PriorityQueue) Len() int { return len(*pq) }
@@ -140,3 +318,33 @@ func (pq *PriorityQueue) Pop() interface{} {
 	*pq = old[0 : n-1]
 	return record
 }
+
+func (eq *ExpiryQueue) Len() int { return len(*eq) }
+
+func (eq *ExpiryQueue) Less(i, j int) bool {
+	q := *eq
+	return q[i].TTL.Before(q[j].TTL)
+}
+
+func (eq *ExpiryQueue) Swap(i, j int) {
+	q := *eq
+	q[i], q[j] = q[j], q[i]
+	q[i].ExpiryIndex = i
+	q[j].ExpiryIndex = j
+}
+
+func (eq *ExpiryQueue) Push(x interface{}) {
+	n := len(*eq)
+	record := x.(*Record)
+	record.ExpiryIndex = n
+	*eq = append(*eq, record)
+}
+
+func (eq *ExpiryQueue) Pop() interface{} {
+	old := *eq
+	n := len(old)
+	record := old[n-1]
+	record.ExpiryIndex = -1 // for safety
+	*eq = old[0 : n-1]
+	return record
+}
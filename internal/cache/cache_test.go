@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	t.Run("with capacity spread across shards", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 10, Shards: 4})
+		capacity := 0
+		for _, s := range cache.shards {
+			capacity += s.Capacity
+		}
+		assert.Equal(t, 10, capacity)
+
+		for i := range 100 {
+			cache.Set("property"+strconv.Itoa(i), i, 0, 1*time.Hour)
+		}
+		assert.Equal(t, 10, cache.Len())
+	})
+
+	t.Run("with more shards than capacity", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 2, Shards: 16})
+		assert.Len(t, cache.shards, 2)
+
+		cache.Set("nytimes.com", 1, 0, 1*time.Hour)
+		value, exists := cache.Get("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, 1, value)
+	})
+}
+
+// BenchmarkCacheGet measures the concurrent lookups of cached records, which should scale with the cores as the number
+// of shards grows, eg go test -bench CacheGet -cpu 1,4,8 ./internal/cache
+func BenchmarkCacheGet(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cache := NewCache(Config{Capacity: 10000, Shards: shards})
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = "property" + strconv.Itoa(i) + ".com"
+				cache.Set(keys[i], i, 0, 1*time.Hour)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Get(keys[i%len(keys)])
+					i += 7
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkCacheSet measures the concurrent writes to a full cache, where each write evicts a record.
+func BenchmarkCacheSet(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cache := NewCache(Config{Capacity: 10000, Shards: shards})
+			keys := make([]string, 100000)
+			for i := range keys {
+				keys[i] = "property" + strconv.Itoa(i) + ".com"
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Set(keys[i%len(keys)], i, 0, 1*time.Hour)
+					i += 7
+				}
+			})
+		})
+	}
+}
+
+func TestReaper(t *testing.T) {
+	t.Run("with expired records", func(t *testing.T) {
+		reclaimed := make(chan int, 10)
+		cache := NewCache(Config{
+			Capacity:    10,
+			GracePeriod: 1 * time.Hour,
+			Shards:      2,
+			Reaper: ReaperConfig{
+				Interval: 1 * time.Millisecond,
+				OnReap: func(count int) {
+					reclaimed <- count
+				},
+			},
+		})
+		defer cache.Stop()
+		cache.Set("nytimes.com", 1, 0, 1*time.Hour)
+		cache.Set("cnn.com", 2, 0, -2*time.Hour)
+		cache.Set("bbc.com", 3, 0, -2*time.Hour)
+		// Expired but still within the grace period
+		cache.Set("espn.com", 4, 0, -1*time.Minute)
+
+		reaped := 0
+		for reaped < 2 {
+			select {
+			case count := <-reclaimed:
+				reaped += count
+			case <-time.After(1 * time.Second):
+				t.Fatal("expired records should be reclaimed")
+			}
+		}
+		assert.Equal(t, 2, reaped)
+		assert.Equal(t, int64(2), cache.Reclaimed())
+		assert.Equal(t, 2, cache.Len())
+		_, _, exists := cache.GetStale("espn.com")
+		assert.True(t, exists)
+	})
+
+	t.Run("with expired records evicted before live ones", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 2, GracePeriod: 1 * time.Hour})
+		cache.Set("nytimes.com", 1, 0, 1*time.Hour)
+		// Expired but still within the grace period, while its priority is higher
+		cache.Set("cnn.com", 2, 5, -1*time.Minute)
+
+		cache.Set("bbc.com", 3, 0, 1*time.Hour)
+		_, _, exists := cache.GetStale("cnn.com")
+		assert.False(t, exists, "cnn.com should be evicted")
+		_, exists = cache.Get("nytimes.com")
+		assert.True(t, exists)
+		_, exists = cache.Get("bbc.com")
+		assert.True(t, exists)
+	})
+
+	t.Run("with incremental sweep", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 100})
+		for i := range 10 {
+			cache.Set("property"+strconv.Itoa(i), i, 0, -1*time.Millisecond)
+		}
+
+		assert.Equal(t, 3, cache.Reap(3))
+		assert.Equal(t, 7, cache.Len())
+		assert.Equal(t, 7, cache.Reap(100))
+		assert.Equal(t, int64(10), cache.Reclaimed())
+	})
+
+	t.Run("with expired records among live ones", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 1000})
+		for i := range 500 {
+			cache.Set("live"+strconv.Itoa(i), i, 0, 1*time.Hour)
+		}
+		for i := range 5 {
+			cache.Set("expired"+strconv.Itoa(i), i, 0, -1*time.Millisecond)
+		}
+
+		// The expired records are reclaimed first, whatever the number of live ones
+		assert.Equal(t, 5, cache.Reap(5))
+		assert.Equal(t, 500, cache.Len())
+		assert.Equal(t, 0, cache.Reap(5))
+	})
+
+	t.Run("with stop", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 10, Reaper: ReaperConfig{Interval: 1 * time.Millisecond}})
+		cache.Stop()
+		// Safe to stop more than once
+		cache.Stop()
+
+		cache.Set("nytimes.com", 1, 0, -1*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		assert.Equal(t, 1, cache.Len(), "no record should be reclaimed once stopped")
+	})
+
+	t.Run("without interval", func(t *testing.T) {
+		cache := NewCache(Config{Capacity: 10})
+		// Returns right away since nothing runs in the background
+		cache.Stop()
+	})
+}
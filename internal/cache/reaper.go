@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReaperBatchSize is how many records of each shard are reclaimed at most on each sweep when not configured.
+const DefaultReaperBatchSize = 100
+
+// ReaperConfig is how the records past their TTL and grace period are removed in the background, instead of only when
+// they are looked up, so that they don't take up the capacity of live records.
+type ReaperConfig struct {
+	// Interval is how often the records are swept. Not set means no background sweep.
+	Interval time.Duration
+	// BatchSize is how many records of each shard are reclaimed at most on each sweep, so that the lock of a shard is
+	// only held briefly. The records are reclaimed in TTL order. Defaults to DefaultReaperBatchSize.
+	BatchSize int
+	// OnReap is called after each sweep that reclaimed records, with how many were reclaimed.
+	OnReap func(reclaimed int)
+}
+
+type reaper struct {
+	cache     *Cache
+	batchSize int
+	onReap    func(reclaimed int)
+	reclaimed atomic.Int64
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// newReaper starts sweeping the cache in the background, if the interval is set.
+func newReaper(cache *Cache, config ReaperConfig) *reaper {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultReaperBatchSize
+	}
+	if config.OnReap == nil {
+		config.OnReap = func(int) {}
+	}
+	r := &reaper{
+		cache:     cache,
+		batchSize: config.BatchSize,
+		onReap:    config.OnReap,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if config.Interval <= 0 {
+		close(r.done)
+		return r
+	}
+	go r.run(config.Interval)
+	return r
+}
+
+func (r *reaper) run(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if reclaimed := r.cache.Reap(r.batchSize); reclaimed > 0 {
+				r.onReap(reclaimed)
+			}
+		}
+	}
+}
+
+// Reap removes up to batchSize records past their TTL and grace period from each shard, one shard at a time. It returns
+// how many records are reclaimed.
+func (c *Cache) Reap(batchSize int) int {
+	now := time.Now()
+	reclaimed := 0
+	for _, s := range c.shards {
+		s.Mutex.Lock()
+		// The records expiring first are at the front of the expiry queue, so the sweep stops at the first live one
+		for checked := 0; checked < batchSize && s.Expiries.Len() > 0; checked++ {
+			record := (*s.Expiries)[0]
+			if !now.After(record.TTL.Add(c.GracePeriod)) {
+				break
+			}
+			s.evict(record.Key)
+			reclaimed++
+		}
+		s.Mutex.Unlock()
+	}
+	c.reaper.reclaimed.Add(int64(reclaimed))
+	return reclaimed
+}
+
+// Reclaimed returns how many records were removed by Reap since the cache was created.
+func (c *Cache) Reclaimed() int64 {
+	return c.reaper.reclaimed.Load()
+}
+
+// Stop stops sweeping the cache in the background, and waits for the sweep in progress if any. It is safe to call it
+// more than once.
+func (c *Cache) Stop() {
+	c.reaper.stopOnce.Do(func() {
+		close(c.reaper.stop)
+	})
+	<-c.reaper.done
+}
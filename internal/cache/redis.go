@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultRedisKeyPrefix is the prefix of the redis keys when not configured.
+const DefaultRedisKeyPrefix = "scope3apiproxy:"
+
+type RedisStoreConfig struct {
+	// Address is the host:port of the redis server
+	Address  string
+	Password string
+	DB       int
+	// KeyPrefix keeps the records apart from the other keys of the redis server. Defaults to DefaultRedisKeyPrefix.
+	KeyPrefix string
+	// Capacity is how many records are kept, where the records with the lowest priority are evicted first. Not set
+	// means no limit, eg when the redis server evicts keys on its own through maxmemory-policy.
+	Capacity int
+	// GracePeriod is how long a record is kept after its TTL so that it can still be served as stale through GetStale
+	GracePeriod time.Duration
+	// PoolSize is how many idle connections are kept. Defaults to 10.
+	PoolSize int
+	// Timeout is the longest a single command waits for the redis server. Not set means no timeout.
+	Timeout time.Duration
+	// Backoff is how long no command is sent once the redis server can't be reached or times out, so that the lookups
+	// miss right away instead of each waiting for the timeout. Defaults to 1s.
+	Backoff time.Duration
+	// OnError is called when the redis server can't be reached or rejects a command. The failed reads are misses,
+	// while the failed writes are dropped, so that the app keeps answering from scope3 server.
+	OnError func(err error)
+}
+
+// RedisStore caches the records in a redis server so that the replicas of the app share them and keep them across
+// restarts. The values are gob encoded, so their concrete types must be registered through gob.Register.
+//
+// The redis key of each record expires once its TTL and the grace period pass. The priorities are kept in a sorted set
+// so that the records with the lowest priority are evicted first once the capacity is reached, while the expiries of the
+// redis keys are kept in another one so that the keys redis server expired on its own are dropped from the priorities
+// first. Unlike Cache, the frequency is not tracked since it would make every read a write.
+//
+// GetRecords and SetRecords take a single round trip to the redis server whatever the number of records (MGET and
+// pipelined commands), so they are preferred over a lookup per record.
+type RedisStore struct {
+	client      *respClient
+	keyPrefix   string
+	capacity    int
+	gracePeriod time.Duration
+	onError     func(err error)
+}
+
+// redisRecord is the value of the redis key of a record.
+type redisRecord struct {
+	Value    interface{}
+	Priority int
+	SoftTTL  time.Time
+	TTL      time.Time
+}
+
+var _ Store = (*RedisStore)(nil)
+
+func NewRedisStore(config RedisStoreConfig) *RedisStore {
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = DefaultRedisKeyPrefix
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = 10
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 1 * time.Second
+	}
+	if config.OnError == nil {
+		config.OnError = func(error) {}
+	}
+	return &RedisStore{
+		client: newRespClient(
+			config.Address,
+			config.Password,
+			config.DB,
+			config.PoolSize,
+			config.Timeout,
+			config.Backoff,
+		),
+		keyPrefix:   config.KeyPrefix,
+		capacity:    config.Capacity,
+		gracePeriod: config.GracePeriod,
+		onError:     config.OnError,
+	}
+}
+
+// Ping checks that the redis server can be reached.
+func (r *RedisStore) Ping() error {
+	_, err := r.client.do("PING")
+	return err
+}
+
+// Close closes the idle connections to the redis server.
+func (r *RedisStore) Close() {
+	r.client.close()
+}
+
+func (r *RedisStore) Get(key string) (interface{}, bool) {
+	value, _, exists := r.GetWithRevalidation(key)
+	return value, exists
+}
+
+func (r *RedisStore) GetWithRevalidation(key string) (value interface{}, revalidate bool, exists bool) {
+	record, exists := r.get(key)
+	now := time.Now()
+	if !exists || now.After(record.TTL) {
+		return nil, false, false
+	}
+	return record.Value, now.After(record.SoftTTL), true
+}
+
+func (r *RedisStore) GetStale(key string) (value interface{}, stale bool, exists bool) {
+	record, exists := r.get(key)
+	if !exists {
+		return nil, false, false
+	}
+	return record.Value, time.Now().After(record.TTL), true
+}
+
+// GetRecord returns the record as is, where the frequency is always 0 since it is not tracked.
+func (r *RedisStore) GetRecord(key string) (Record, bool) {
+	record, exists := r.get(key)
+	if !exists {
+		return Record{}, false
+	}
+	return Record{
+		Key:      key,
+		Value:    record.Value,
+		Priority: record.Priority,
+		SoftTTL:  record.SoftTTL,
+		TTL:      record.TTL,
+	}, true
+}
+
+// GetRecords returns the records of the keys through a single MGET, where the frequency is always 0 since it is not
+// tracked.
+func (r *RedisStore) GetRecords(keys []string) map[string]Record {
+	redisRecords := r.getMany(keys)
+	records := make(map[string]Record, len(redisRecords))
+	for key, record := range redisRecords {
+		records[key] = Record{
+			Key:      key,
+			Value:    record.Value,
+			Priority: record.Priority,
+			SoftTTL:  record.SoftTTL,
+			TTL:      record.TTL,
+		}
+	}
+	return records
+}
+
+func (r *RedisStore) Set(key string, value interface{}, priority int, ttl time.Duration) {
+	r.SetWithSoftTTL(key, value, priority, ttl, ttl)
+}
+
+func (r *RedisStore) SetWithSoftTTL(key string, value interface{}, priority int, softTtl time.Duration, ttl time.Duration) {
+	now := time.Now()
+	r.SetRecords([]Record{{
+		Key:      key,
+		Value:    value,
+		Priority: priority,
+		SoftTTL:  now.Add(softTtl),
+		TTL:      now.Add(ttl),
+	}})
+}
+
+// SetRecords caches the records along with their priorities and expiries through pipelined commands, then evicts the
+// records with the lowest priority over the capacity once the expired ones are dropped.
+func (r *RedisStore) SetRecords(records []Record) {
+	now := time.Now()
+	commands := make([][]string, 0, len(records)+4)
+	trackPriorities := []string{"ZADD", r.priorityKey()}
+	trackExpiries := []string{"ZADD", r.expiryKey()}
+	for _, record := range records {
+		var buffer bytes.Buffer
+		err := gob.NewEncoder(&buffer).Encode(redisRecord{
+			Value:    record.Value,
+			Priority: record.Priority,
+			SoftTTL:  record.SoftTTL,
+			TTL:      record.TTL,
+		})
+		if err != nil {
+			r.onError(fmt.Errorf("unable to encode the record %s: %w", record.Key, err))
+			continue
+		}
+		// The key is kept within the grace period so that GetStale can still serve it
+		expiry := max(record.TTL.Sub(now)+r.gracePeriod, time.Millisecond)
+		commands = append(commands, []string{
+			"SET", r.recordKey(record.Key), buffer.String(), "PX", strconv.FormatInt(expiry.Milliseconds(), 10),
+		})
+		trackPriorities = append(trackPriorities, strconv.Itoa(record.Priority), record.Key)
+		trackExpiries = append(trackExpiries, strconv.FormatInt(now.Add(expiry).UnixMilli(), 10), record.Key)
+	}
+	if len(commands) == 0 {
+		return
+	}
+	if r.capacity > 0 {
+		commands = append(commands,
+			trackPriorities,
+			trackExpiries,
+			[]string{"ZRANGEBYSCORE", r.expiryKey(), "-inf", strconv.FormatInt(now.UnixMilli(), 10)},
+			[]string{"ZCARD", r.priorityKey()},
+		)
+	}
+
+	replies, err := r.client.pipeline(commands...)
+	if err != nil {
+		r.onError(fmt.Errorf("unable to cache the records: %w", err))
+		return
+	}
+	failed := false
+	for i, reply := range replies {
+		if replyError, ok := reply.(respError); ok {
+			r.onError(fmt.Errorf("unable to %s: %w", commands[i][0], replyError))
+			failed = true
+		}
+	}
+	if r.capacity > 0 && !failed {
+		recordCount, _ := replies[len(replies)-1].(int64)
+		expiredKeys := bulkStrings(replies[len(replies)-2])
+		if len(expiredKeys) > 0 && !r.untrack(expiredKeys) {
+			return
+		}
+		r.evictOverCapacity(recordCount - int64(len(expiredKeys)) - int64(r.capacity))
+	}
+}
+
+func (r *RedisStore) get(key string) (redisRecord, bool) {
+	record, exists := r.getMany([]string{key})[key]
+	return record, exists
+}
+
+// getMany returns the records of the keys through a single MGET, keyed by their key.
+func (r *RedisStore) getMany(keys []string) map[string]redisRecord {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]string, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, key := range keys {
+		args = append(args, r.recordKey(key))
+	}
+	reply, err := r.client.do(args...)
+	if err != nil {
+		r.onError(fmt.Errorf("unable to get the records: %w", err))
+		return nil
+	}
+	values, _ := reply.([]interface{})
+	records := make(map[string]redisRecord, len(values))
+	var expiredKeys []string
+	for i, value := range values[:min(len(values), len(keys))] {
+		encoded, ok := value.([]byte)
+		if !ok {
+			expiredKeys = append(expiredKeys, keys[i])
+			continue
+		}
+		var record redisRecord
+		if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&record); err != nil {
+			r.onError(fmt.Errorf("unable to decode the record %s: %w", keys[i], err))
+			continue
+		}
+		records[keys[i]] = record
+	}
+	if r.capacity > 0 && len(expiredKeys) > 0 {
+		// The keys expired, so their priorities are no longer needed
+		r.untrack(expiredKeys)
+	}
+	return records
+}
+
+// untrack drops the priorities and the expiries of the keys. It returns false when the commands failed.
+func (r *RedisStore) untrack(keys []string) bool {
+	replies, err := r.client.pipeline(
+		append([]string{"ZREM", r.priorityKey()}, keys...),
+		append([]string{"ZREM", r.expiryKey()}, keys...),
+	)
+	if err != nil {
+		r.onError(fmt.Errorf("unable to ZREM: %w", err))
+		return false
+	}
+	for _, reply := range replies {
+		if replyError, ok := reply.(respError); ok {
+			r.onError(fmt.Errorf("unable to ZREM: %w", replyError))
+			return false
+		}
+	}
+	return true
+}
+
+// evictOverCapacity evicts the given number of records with the lowest priority.
+func (r *RedisStore) evictOverCapacity(overCapacity int64) {
+	if overCapacity <= 0 {
+		return
+	}
+	reply, err := r.client.do("ZPOPMIN", r.priorityKey(), strconv.FormatInt(overCapacity, 10))
+	if err != nil {
+		r.onError(fmt.Errorf("unable to evict the records: %w", err))
+		return
+	}
+	// The reply is the evicted keys, each followed by its priority
+	evicted, _ := reply.([]interface{})
+	deleteRecords := []string{"DEL"}
+	untrackExpiries := []string{"ZREM", r.expiryKey()}
+	for i := 0; i < len(evicted); i += 2 {
+		if evictedKey, ok := evicted[i].([]byte); ok {
+			deleteRecords = append(deleteRecords, r.recordKey(string(evictedKey)))
+			untrackExpiries = append(untrackExpiries, string(evictedKey))
+		}
+	}
+	if len(deleteRecords) == 1 {
+		return
+	}
+	if _, err := r.client.pipeline(deleteRecords, untrackExpiries); err != nil {
+		r.onError(fmt.Errorf("unable to evict the records: %w", err))
+	}
+}
+
+func (r *RedisStore) recordKey(key string) string {
+	return r.keyPrefix + "record:" + key
+}
+
+// priorityKey is the sorted set of the keys of the records by priority.
+func (r *RedisStore) priorityKey() string {
+	return r.keyPrefix + "priorities"
+}
+
+// expiryKey is the sorted set of the keys of the records by expiry of their redis key, in unix milliseconds.
+func (r *RedisStore) expiryKey() string {
+	return r.keyPrefix + "expiries"
+}
+
+// bulkStrings returns the bulk strings of an array reply (eg, the members of ZRANGEBYSCORE).
+func bulkStrings(reply interface{}) []string {
+	values, _ := reply.([]interface{})
+	var strs []string
+	for _, value := range values {
+		if str, ok := value.([]byte); ok {
+			strs = append(strs, string(str))
+		}
+	}
+	return strs
+}
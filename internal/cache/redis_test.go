@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testValue struct {
+	Name string
+}
+
+func init() {
+	gob.Register(&testValue{})
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Run("with records shared across stores", func(t *testing.T) {
+		address := startFakeRedisServer(t)
+		store := NewRedisStore(RedisStoreConfig{Address: address})
+		otherReplicaStore := NewRedisStore(RedisStoreConfig{Address: address})
+
+		assert.NoError(t, store.Ping())
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Hour)
+		value, exists := otherReplicaStore.Get("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, &testValue{Name: "nytimes.com"}, value)
+
+		_, exists = otherReplicaStore.Get("cnn.com")
+		assert.False(t, exists)
+	})
+
+	t.Run("with soft TTL", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t)})
+
+		store.SetWithSoftTTL("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Millisecond, 1*time.Hour)
+		time.Sleep(5 * time.Millisecond)
+		value, revalidate, exists := store.GetWithRevalidation("nytimes.com")
+		assert.True(t, exists)
+		assert.True(t, revalidate)
+		assert.Equal(t, &testValue{Name: "nytimes.com"}, value)
+	})
+
+	t.Run("with expired record within grace period", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t), GracePeriod: 1 * time.Hour})
+
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		_, exists := store.Get("nytimes.com")
+		assert.False(t, exists)
+		value, stale, exists := store.GetStale("nytimes.com")
+		assert.True(t, exists)
+		assert.True(t, stale)
+		assert.Equal(t, &testValue{Name: "nytimes.com"}, value)
+	})
+
+	t.Run("with expired record past grace period", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t)})
+
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		_, _, exists := store.GetStale("nytimes.com")
+		assert.False(t, exists)
+	})
+
+	t.Run("with priority based eviction", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t), Capacity: 2})
+
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 1, 1*time.Hour)
+		store.Set("cnn.com", &testValue{Name: "cnn.com"}, 5, 1*time.Hour)
+		store.Set("bbc.com", &testValue{Name: "bbc.com"}, 3, 1*time.Hour)
+		_, exists := store.Get("nytimes.com")
+		assert.False(t, exists, "nytimes.com should be evicted")
+		_, exists = store.Get("cnn.com")
+		assert.True(t, exists)
+		_, exists = store.Get("bbc.com")
+		assert.True(t, exists)
+	})
+
+	t.Run("with expired records evicted before live ones", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t), Capacity: 2})
+
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 5, 1*time.Millisecond)
+		store.Set("cnn.com", &testValue{Name: "cnn.com"}, 5, 1*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		// The expired records are still tracked since they aren't looked up again, yet they don't count anymore
+		store.Set("bbc.com", &testValue{Name: "bbc.com"}, 0, 1*time.Hour)
+		store.Set("lemonde.fr", &testValue{Name: "lemonde.fr"}, 0, 1*time.Hour)
+		_, exists := store.Get("bbc.com")
+		assert.True(t, exists)
+		_, exists = store.Get("lemonde.fr")
+		assert.True(t, exists)
+	})
+
+	t.Run("with password and db", func(t *testing.T) {
+		store := NewRedisStore(RedisStoreConfig{Address: startFakeRedisServer(t), Password: "secret", DB: 1})
+
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Hour)
+		_, exists := store.Get("nytimes.com")
+		assert.True(t, exists)
+	})
+
+	t.Run("with unreachable redis server", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		listener.Close()
+		var errs []error
+		store := NewRedisStore(RedisStoreConfig{
+			Address: listener.Addr().String(),
+			Timeout: 100 * time.Millisecond,
+			OnError: func(err error) {
+				errs = append(errs, err)
+			},
+		})
+
+		// Failures are misses, so that the app keeps answering from scope3 server
+		store.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 0, 1*time.Hour)
+		_, exists := store.Get("nytimes.com")
+		assert.False(t, exists)
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("with records looked up at once", func(t *testing.T) {
+		address, server := startFakeRedisServerWithCommands(t)
+		store := NewRedisStore(RedisStoreConfig{Address: address, Capacity: 10})
+
+		now := time.Now()
+		store.SetRecords([]Record{
+			{Key: "nytimes.com", Value: &testValue{Name: "nytimes.com"}, Priority: 1, SoftTTL: now.Add(1 * time.Hour), TTL: now.Add(1 * time.Hour)},
+			{Key: "cnn.com", Value: &testValue{Name: "cnn.com"}, Priority: 2, SoftTTL: now.Add(1 * time.Hour), TTL: now.Add(1 * time.Hour)},
+		})
+		records := store.GetRecords([]string{"nytimes.com", "cnn.com", "bbc.com"})
+		assert.Len(t, records, 2)
+		assert.Equal(t, &testValue{Name: "cnn.com"}, records["cnn.com"].Value)
+		assert.Equal(t, 2, records["cnn.com"].Priority)
+		// SET x2 + ZADD x2 + ZRANGEBYSCORE + ZCARD pipelined, then MGET + ZREM x2 of the missing key
+		assert.Equal(t,
+			[]string{"SET", "SET", "ZADD", "ZADD", "ZRANGEBYSCORE", "ZCARD", "MGET", "ZREM", "ZREM"},
+			server.commandNames(),
+		)
+	})
+
+	t.Run("with backoff once redis server is unreachable", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		listener.Close()
+		store := NewRedisStore(RedisStoreConfig{Address: listener.Addr().String(), Backoff: 50 * time.Millisecond})
+
+		assert.Error(t, store.Ping())
+		// Fails fast without dialing the redis server until the backoff is over
+		assert.ErrorIs(t, store.Ping(), errRespUnavailable)
+		time.Sleep(60 * time.Millisecond)
+		err := store.Ping()
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, errRespUnavailable)
+	})
+}
+
+// startFakeRedisServer starts an in-process stand-in of redis server that supports the commands used by RedisStore. It
+// is closed at the end of the test.
+func startFakeRedisServer(t *testing.T) string {
+	t.Helper()
+	address, _ := startFakeRedisServerWithCommands(t)
+	return address
+}
+
+// startFakeRedisServerWithCommands is startFakeRedisServer, where the server keeps the name of each command it runs.
+func startFakeRedisServerWithCommands(t *testing.T) (string, *fakeRedisServer) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start fake redis server: %v", err)
+	}
+	server := &fakeRedisServer{
+		values:     map[string]string{},
+		expiries:   map[string]time.Time{},
+		sortedSets: map[string]map[string]float64{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return listener.Addr().String(), server
+}
+
+type fakeRedisServer struct {
+	values     map[string]string
+	expiries   map[string]time.Time
+	sortedSets map[string]map[string]float64
+	commands   []string
+	mutex      sync.Mutex
+}
+
+func (s *fakeRedisServer) commandNames() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		command, err := readRespReply(reader)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range command.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		if _, err := conn.Write([]byte(s.execute(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRedisServer) execute(args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.commands = append(s.commands, strings.ToUpper(args[0]))
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		s.values[args[1]] = args[2]
+		delete(s.expiries, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			milliseconds, _ := strconv.Atoi(args[4])
+			s.expiries[args[1]] = time.Now().Add(time.Duration(milliseconds) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		return s.get(args[1])
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			reply += s.get(key)
+		}
+		return reply
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, exists := s.values[key]; exists {
+				deleted++
+			}
+			delete(s.values, key)
+			delete(s.expiries, key)
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "ZADD":
+		if s.sortedSets[args[1]] == nil {
+			s.sortedSets[args[1]] = map[string]float64{}
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			s.sortedSets[args[1]][args[i+1]] = score
+		}
+		return fmt.Sprintf(":%d\r\n", (len(args)-2)/2)
+	case "ZREM":
+		for _, member := range args[2:] {
+			delete(s.sortedSets[args[1]], member)
+		}
+		return fmt.Sprintf(":%d\r\n", len(args)-2)
+	case "ZRANGEBYSCORE":
+		maxScore, _ := strconv.ParseFloat(args[3], 64)
+		var members []string
+		for member, score := range s.sortedSets[args[1]] {
+			if score <= maxScore {
+				members = append(members, member)
+			}
+		}
+		reply := fmt.Sprintf("*%d\r\n", len(members))
+		for _, member := range members {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(member), member)
+		}
+		return reply
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(s.sortedSets[args[1]]))
+	case "ZPOPMIN":
+		count, _ := strconv.Atoi(args[2])
+		members := make([]string, 0, len(s.sortedSets[args[1]]))
+		for member := range s.sortedSets[args[1]] {
+			members = append(members, member)
+		}
+		slices.SortFunc(members, func(a, b string) int {
+			if scoreA, scoreB := s.sortedSets[args[1]][a], s.sortedSets[args[1]][b]; scoreA != scoreB {
+				return int(scoreA - scoreB)
+			}
+			return strings.Compare(a, b)
+		})
+		members = members[:min(count, len(members))]
+		reply := fmt.Sprintf("*%d\r\n", len(members)*2)
+		for _, member := range members {
+			score := strconv.FormatFloat(s.sortedSets[args[1]][member], 'f', -1, 64)
+			reply += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(member), member, len(score), score)
+			delete(s.sortedSets[args[1]], member)
+		}
+		return reply
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// get returns the bulk string reply of the value of the key, nil once expired.
+func (s *fakeRedisServer) get(key string) string {
+	if expiry, exists := s.expiries[key]; exists && time.Now().After(expiry) {
+		delete(s.values, key)
+		delete(s.expiries, key)
+	}
+	value, exists := s.values[key]
+	if !exists {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// errRespUnavailable is returned without calling the redis server while it is unavailable, so that the callers fail fast
+// instead of waiting for each of their commands to time out.
+var errRespUnavailable = errors.New("redis server is unavailable, no command is sent until the backoff is over")
+
+// respClient is a minimal client of the redis protocol (RESP), with a pool of connections. Only the commands used by
+// RedisStore are needed, so the replies are returned as plain go values: string for simple strings, int64 for integers,
+// []byte for bulk strings (nil when missing), and []interface{} for arrays.
+//
+// Once the redis server can't be reached or times out, no command is sent until the backoff is over.
+type respClient struct {
+	address  string
+	password string
+	db       int
+	timeout  time.Duration
+	backoff  time.Duration
+	conns    chan *respConn
+	// unavailableUntil is the end of the backoff in unix nanoseconds
+	unavailableUntil atomic.Int64
+}
+
+type respConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// respError is the error reply of the redis server (eg, wrong type of key).
+type respError struct {
+	Message string
+}
+
+func (e respError) Error() string {
+	return "redis error: " + e.Message
+}
+
+func newRespClient(
+	address string,
+	password string,
+	db int,
+	poolSize int,
+	timeout time.Duration,
+	backoff time.Duration,
+) *respClient {
+	return &respClient{
+		address:  address,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+		backoff:  backoff,
+		conns:    make(chan *respConn, poolSize),
+	}
+}
+
+// do sends the command and returns its reply.
+func (c *respClient) do(args ...string) (interface{}, error) {
+	replies, err := c.pipeline(args)
+	if err != nil {
+		return nil, err
+	}
+	if replyError, ok := replies[0].(respError); ok {
+		return nil, replyError
+	}
+	return replies[0], nil
+}
+
+// pipeline sends the commands at once then reads their replies in the same order, so that they take a single round
+// trip. A command rejected by the redis server has its respError as reply, without failing the other commands. The
+// connection is reused afterward, unless it failed.
+func (c *respClient) pipeline(commands ...[]string) ([]interface{}, error) {
+	conn, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.pipeline(c.timeout, commands)
+	if err != nil {
+		// The connection may be left in the middle of a reply, so it can't be reused
+		conn.conn.Close()
+		c.backOffOnTimeout(err)
+		return nil, err
+	}
+	c.release(conn)
+	return replies, nil
+}
+
+func (c *respClient) acquire() (*respConn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	if time.Now().UnixNano() < c.unavailableUntil.Load() {
+		return nil, errRespUnavailable
+	}
+	netConn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		c.unavailableUntil.Store(time.Now().Add(c.backoff).UnixNano())
+		return nil, err
+	}
+	conn := &respConn{conn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}
+	if c.password != "" {
+		if _, err := conn.do(c.timeout, "AUTH", c.password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// backOffOnTimeout starts the backoff when the redis server didn't answer in time. Other errors (eg, a pooled connection
+// closed by the redis server) are left to the next dial.
+func (c *respClient) backOffOnTimeout(err error) {
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		c.unavailableUntil.Store(time.Now().Add(c.backoff).UnixNano())
+	}
+}
+
+func (c *respClient) release(conn *respConn) {
+	select {
+	case c.conns <- conn:
+	default:
+		// The pool is full
+		conn.conn.Close()
+	}
+}
+
+func (c *respClient) close() {
+	for {
+		select {
+		case conn := <-c.conns:
+			conn.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (c *respConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	replies, err := c.pipeline(timeout, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if replyError, ok := replies[0].(respError); ok {
+		return nil, replyError
+	}
+	return replies[0], nil
+}
+
+// pipeline sends the commands then reads their replies, where the error replies are returned as respError values.
+func (c *respConn) pipeline(timeout time.Duration, commands [][]string) ([]interface{}, error) {
+	if timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	for _, args := range commands {
+		if err := writeRespCommand(c.writer, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(commands))
+	for i := range replies {
+		reply, err := readRespReply(c.reader)
+		var replyError respError
+		switch {
+		case errors.As(err, &replyError):
+			replies[i] = replyError
+		case err != nil:
+			return nil, err
+		default:
+			replies[i] = reply
+		}
+	}
+	return replies, nil
+}
+
+// writeRespCommand writes the command as an array of bulk strings.
+func writeRespCommand(writer *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(writer, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRespReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	kind, content := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return content, nil
+	case '-':
+		return nil, respError{Message: content}
+	case ':':
+		return strconv.ParseInt(content, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(content)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, bulk); err != nil {
+			return nil, err
+		}
+		return bulk[:size], nil
+	case '*':
+		size, err := strconv.Atoi(content)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, size)
+		for i := range array {
+			if array[i], err = readRespReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type: %q", kind)
+	}
+}
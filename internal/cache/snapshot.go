@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotRecord is a record as saved in the snapshot file. The values are gob encoded, so their concrete types must be
+// registered through gob.Register.
+type snapshotRecord struct {
+	Key       string
+	Value     interface{}
+	Priority  int
+	Frequency int
+	SoftTTL   time.Time
+	TTL       time.Time
+}
+
+// SaveSnapshot saves the records of the cache to the file, so that LoadSnapshot warms up the cache after a restart. The
+// file is replaced at once, so a crash while saving leaves the previous snapshot as is.
+func (c *Cache) SaveSnapshot(path string) error {
+	var records []snapshotRecord
+	for _, s := range c.shards {
+		s.Mutex.Lock()
+		for _, record := range s.Record {
+			records = append(records, snapshotRecord{
+				Key:       record.Key,
+				Value:     record.Value,
+				Priority:  record.Priority,
+				Frequency: record.Frequency,
+				SoftTTL:   record.SoftTTL,
+				TTL:       record.TTL,
+			})
+		}
+		s.Mutex.Unlock()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := gob.NewEncoder(file).Encode(records); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// LoadSnapshot adds the records saved by SaveSnapshot to the cache, except the expired ones and the ones already in the
+// cache. It returns how many records are loaded, where a missing file loads none (eg, on the first start).
+func (c *Cache) LoadSnapshot(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var records []snapshotRecord
+	if err := gob.NewDecoder(file).Decode(&records); err != nil {
+		return 0, err
+	}
+
+	recordsByShard := make(map[*shard][]snapshotRecord, len(c.shards))
+	for _, record := range records {
+		s := c.shardOf(record.Key)
+		recordsByShard[s] = append(recordsByShard[s], record)
+	}
+	loaded := 0
+	for s, shardRecords := range recordsByShard {
+		loaded += s.load(shardRecords)
+	}
+	return loaded, nil
+}
+
+// load adds the records of the shard, then evicts the records over its capacity. It returns how many records are kept.
+func (s *shard) load(records []snapshotRecord) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	now := time.Now()
+	var loadedKeys []string
+	for _, record := range records {
+		if _, exists := s.Record[record.Key]; exists || now.After(record.TTL) {
+			continue
+		}
+		loadedRecord := &Record{
+			Key:       record.Key,
+			Value:     record.Value,
+			Priority:  record.Priority,
+			Frequency: record.Frequency,
+			SoftTTL:   record.SoftTTL,
+			TTL:       record.TTL,
+		}
+		s.push(loadedRecord)
+		loadedKeys = append(loadedKeys, record.Key)
+	}
+	// Evicted once every record is loaded so that the eviction policy picks among all of them
+	s.evictDownTo(s.Capacity)
+	loaded := 0
+	for _, key := range loadedKeys {
+		if _, exists := s.Record[key]; exists {
+			loaded++
+		}
+	}
+	return loaded
+}
+
+// SaveSnapshots saves the snapshot of the cache to the file on each interval until the context is done. onSaved is called
+// after each save with its error, if any. Nothing is saved when the interval is not set.
+func (c *Cache) SaveSnapshots(ctx context.Context, path string, interval time.Duration, onSaved func(err error)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			onSaved(c.SaveSnapshot(path))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("with warm start", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		cache := NewCache(Config{Capacity: 10})
+		cache.SetWithSoftTTL("nytimes.com", &testValue{Name: "nytimes.com"}, 5, 1*time.Millisecond, 1*time.Hour)
+		cache.Get("nytimes.com")
+		cache.Set("cnn.com", &testValue{Name: "cnn.com"}, 0, 1*time.Millisecond)
+		assert.NoError(t, cache.SaveSnapshot(path))
+		time.Sleep(5 * time.Millisecond)
+
+		restartedCache := NewCache(Config{Capacity: 10})
+		loaded, err := restartedCache.LoadSnapshot(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, loaded, "cnn.com should be dropped since it has expired")
+
+		savedRecord, _ := cache.GetRecord("nytimes.com")
+		record, exists := restartedCache.GetRecord("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, &testValue{Name: "nytimes.com"}, record.Value)
+		assert.Equal(t, 5, record.Priority)
+		assert.Equal(t, 2, record.Frequency)
+		assert.True(t, savedRecord.SoftTTL.Equal(record.SoftTTL))
+		assert.True(t, savedRecord.TTL.Equal(record.TTL))
+		_, revalidate, _ := restartedCache.GetWithRevalidation("nytimes.com")
+		assert.True(t, revalidate)
+		_, exists = restartedCache.Get("cnn.com")
+		assert.False(t, exists)
+	})
+
+	t.Run("with snapshot over capacity", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		cache := NewCache(Config{Capacity: 3})
+		cache.Set("nytimes.com", &testValue{Name: "nytimes.com"}, 1, 1*time.Hour)
+		cache.Set("cnn.com", &testValue{Name: "cnn.com"}, 5, 1*time.Hour)
+		cache.Set("bbc.com", &testValue{Name: "bbc.com"}, 3, 1*time.Hour)
+		assert.NoError(t, cache.SaveSnapshot(path))
+
+		restartedCache := NewCache(Config{Capacity: 2})
+		loaded, err := restartedCache.LoadSnapshot(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, loaded)
+		_, exists := restartedCache.Get("nytimes.com")
+		assert.False(t, exists, "nytimes.com should be evicted since it has the lowest priority")
+	})
+
+	t.Run("with records already cached", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		cache := NewCache(Config{Capacity: 10})
+		cache.Set("nytimes.com", &testValue{Name: "old"}, 0, 1*time.Hour)
+		assert.NoError(t, cache.SaveSnapshot(path))
+
+		restartedCache := NewCache(Config{Capacity: 10})
+		restartedCache.Set("nytimes.com", &testValue{Name: "new"}, 0, 1*time.Hour)
+		loaded, err := restartedCache.LoadSnapshot(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, loaded)
+		value, _ := restartedCache.Get("nytimes.com")
+		assert.Equal(t, &testValue{Name: "new"}, value)
+	})
+
+	t.Run("without snapshot file", func(t *testing.T) {
+		loaded, err := NewCache(Config{Capacity: 10}).LoadSnapshot(filepath.Join(t.TempDir(), "cache.snapshot"))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, loaded)
+	})
+
+	t.Run("with corrupted snapshot file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		assert.NoError(t, os.WriteFile(path, []byte("corrupted"), 0o600))
+
+		_, err := NewCache(Config{Capacity: 10}).LoadSnapshot(path)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,31 @@
+package cache
+
+import "time"
+
+// Store is where the records are cached, either in memory of the app (Cache) or shared across replicas (RedisStore).
+//
+// Priority is how important the record is to keep once the store is full, while the expired records are kept for the
+// grace period of the store so that GetStale can still serve them.
+type Store interface {
+	// Get returns the value of the record only if it has not expired yet.
+	Get(key string) (interface{}, bool)
+	// GetWithRevalidation is the same as Get, where revalidate is true when the soft TTL of the record has passed.
+	GetWithRevalidation(key string) (value interface{}, revalidate bool, exists bool)
+	// GetStale returns the value of the record even if it has expired, as long as it is still within the grace period.
+	GetStale(key string) (value interface{}, stale bool, exists bool)
+	// GetRecord returns a copy of the record along with its priority and TTLs, as long as it is still within the grace
+	// period.
+	GetRecord(key string) (Record, bool)
+	// GetRecords looks up the records of the keys at once (eg, in a single round trip to the redis server), where each
+	// record not expired yet counts as a use the same way as GetWithRevalidation. It returns a copy of the records still
+	// within the grace period, keyed by their key.
+	GetRecords(keys []string) map[string]Record
+	Set(key string, value interface{}, priority int, ttl time.Duration)
+	// SetWithSoftTTL caches the value until ttl, where the record should be refreshed once softTtl passes.
+	SetWithSoftTTL(key string, value interface{}, priority int, softTtl time.Duration, ttl time.Duration)
+	// SetRecords caches the records at once, each until its TTL, same as SetWithSoftTTL. Their frequency and index are
+	// ignored.
+	SetRecords(records []Record)
+}
+
+var _ Store = (*Cache)(nil)
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type TieredStoreConfig struct {
+	// L1 is the near cache, usually Cache in memory of the app
+	L1 Store
+	// L1Ttl is the longest a record is kept in L1, so that the records refreshed by other replicas in L2 are picked up.
+	// Not set means the TTL of the record.
+	L1Ttl time.Duration
+	// L2 is the cache shared across replicas, usually RedisStore
+	L2 Store
+	// L2Ttl is the longest a record is kept in L2. Not set means the TTL of the record.
+	L2Ttl time.Duration
+}
+
+// TieredStore looks up the records in L1 first, then in L2. The records found in L2 are promoted into L1 so that the
+// next lookups of hot records don't leave the app, while the records are written through both tiers.
+type TieredStore struct {
+	l1    Store
+	l1Ttl time.Duration
+	l2    Store
+	l2Ttl time.Duration
+	// Counters of the lookups
+	l1Hits     atomic.Int64
+	l2Hits     atomic.Int64
+	misses     atomic.Int64
+	promotions atomic.Int64
+}
+
+// TieredStoreStats is which tier served the lookups since the app started.
+type TieredStoreStats struct {
+	L1Hits     int64 `json:"l1Hits"`
+	L2Hits     int64 `json:"l2Hits"`
+	Misses     int64 `json:"misses"`
+	Promotions int64 `json:"promotions"`
+}
+
+var _ Store = (*TieredStore)(nil)
+
+func NewTieredStore(config TieredStoreConfig) *TieredStore {
+	return &TieredStore{
+		l1:    config.L1,
+		l1Ttl: config.L1Ttl,
+		l2:    config.L2,
+		l2Ttl: config.L2Ttl,
+	}
+}
+
+func (t *TieredStore) Get(key string) (interface{}, bool) {
+	value, _, exists := t.GetWithRevalidation(key)
+	return value, exists
+}
+
+func (t *TieredStore) GetWithRevalidation(key string) (value interface{}, revalidate bool, exists bool) {
+	if value, revalidate, exists := t.l1.GetWithRevalidation(key); exists {
+		t.l1Hits.Add(1)
+		return value, revalidate, true
+	}
+	record, exists := t.l2.GetRecord(key)
+	now := time.Now()
+	if !exists || now.After(record.TTL) {
+		t.misses.Add(1)
+		return nil, false, false
+	}
+	t.l2Hits.Add(1)
+	t.promote(key, record)
+	return record.Value, now.After(record.SoftTTL), true
+}
+
+// GetStale prefers a fresh record of L2 over a stale record of L1, since another replica may have refreshed it already.
+func (t *TieredStore) GetStale(key string) (value interface{}, stale bool, exists bool) {
+	l1Value, l1Stale, l1Exists := t.l1.GetStale(key)
+	if l1Exists && !l1Stale {
+		t.l1Hits.Add(1)
+		return l1Value, false, true
+	}
+	record, exists := t.l2.GetRecord(key)
+	switch {
+	case exists && !time.Now().After(record.TTL):
+		t.l2Hits.Add(1)
+		t.promote(key, record)
+		return record.Value, false, true
+	case l1Exists:
+		t.l1Hits.Add(1)
+		return l1Value, true, true
+	case exists:
+		t.l2Hits.Add(1)
+		return record.Value, true, true
+	default:
+		t.misses.Add(1)
+		return nil, false, false
+	}
+}
+
+func (t *TieredStore) GetRecord(key string) (Record, bool) {
+	if record, exists := t.l1.GetRecord(key); exists {
+		return record, true
+	}
+	return t.l2.GetRecord(key)
+}
+
+// GetRecords looks up the keys in L1 first, then the ones not fresh in L1 in L2 at once. The stale records count as
+// misses, same as GetWithRevalidation, where the one of L1 is preferred.
+func (t *TieredStore) GetRecords(keys []string) map[string]Record {
+	records := t.l1.GetRecords(keys)
+	now := time.Now()
+	var l2Keys []string
+	for _, key := range keys {
+		if record, exists := records[key]; exists && !now.After(record.TTL) {
+			t.l1Hits.Add(1)
+			continue
+		}
+		l2Keys = append(l2Keys, key)
+	}
+	if len(l2Keys) == 0 {
+		return records
+	}
+
+	l2Records := t.l2.GetRecords(l2Keys)
+	var promoted []Record
+	for _, key := range l2Keys {
+		record, exists := l2Records[key]
+		switch {
+		case exists && !now.After(record.TTL):
+			t.l2Hits.Add(1)
+			records[key] = record
+			record.TTL = now.Add(capTtl(record.TTL.Sub(now), t.l1Ttl))
+			promoted = append(promoted, record)
+		case exists:
+			t.misses.Add(1)
+			if _, l1Exists := records[key]; !l1Exists {
+				records[key] = record
+			}
+		default:
+			t.misses.Add(1)
+		}
+	}
+	if len(promoted) > 0 {
+		t.l1.SetRecords(promoted)
+		t.promotions.Add(int64(len(promoted)))
+	}
+	return records
+}
+
+func (t *TieredStore) Set(key string, value interface{}, priority int, ttl time.Duration) {
+	t.SetWithSoftTTL(key, value, priority, ttl, ttl)
+}
+
+func (t *TieredStore) SetWithSoftTTL(key string, value interface{}, priority int, softTtl time.Duration, ttl time.Duration) {
+	t.l2.SetWithSoftTTL(key, value, priority, softTtl, capTtl(ttl, t.l2Ttl))
+	t.l1.SetWithSoftTTL(key, value, priority, softTtl, capTtl(ttl, t.l1Ttl))
+}
+
+func (t *TieredStore) SetRecords(records []Record) {
+	now := time.Now()
+	l1Records := make([]Record, len(records))
+	l2Records := make([]Record, len(records))
+	for i, record := range records {
+		l1Records[i], l2Records[i] = record, record
+		l1Records[i].TTL = now.Add(capTtl(record.TTL.Sub(now), t.l1Ttl))
+		l2Records[i].TTL = now.Add(capTtl(record.TTL.Sub(now), t.l2Ttl))
+	}
+	t.l2.SetRecords(l2Records)
+	t.l1.SetRecords(l1Records)
+}
+
+func (t *TieredStore) Stats() TieredStoreStats {
+	return TieredStoreStats{
+		L1Hits:     t.l1Hits.Load(),
+		L2Hits:     t.l2Hits.Load(),
+		Misses:     t.misses.Load(),
+		Promotions: t.promotions.Load(),
+	}
+}
+
+// promote caches the record of L2 into L1 for the rest of its TTL.
+func (t *TieredStore) promote(key string, record Record) {
+	now := time.Now()
+	t.l1.SetWithSoftTTL(
+		key,
+		record.Value,
+		record.Priority,
+		record.SoftTTL.Sub(now),
+		capTtl(record.TTL.Sub(now), t.l1Ttl),
+	)
+	t.promotions.Add(1)
+}
+
+// capTtl returns the ttl of the record, up to the ttl of the tier if set.
+func capTtl(ttl time.Duration, tierTtl time.Duration) time.Duration {
+	if tierTtl > 0 && tierTtl < ttl {
+		return tierTtl
+	}
+	return ttl
+}
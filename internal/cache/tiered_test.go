@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTieredStore(t *testing.T) {
+	t.Run("with write through both tiers", func(t *testing.T) {
+		l1, l2, store := createTestTieredStore(0)
+
+		store.Set("nytimes.com", "emissions", 1, 1*time.Hour)
+		_, exists := l1.Get("nytimes.com")
+		assert.True(t, exists)
+		_, exists = l2.Get("nytimes.com")
+		assert.True(t, exists)
+
+		value, exists := store.Get("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, "emissions", value)
+		assert.Equal(t, TieredStoreStats{L1Hits: 1}, store.Stats())
+	})
+
+	t.Run("with L2 hit promoted into L1", func(t *testing.T) {
+		l1, l2, store := createTestTieredStore(0)
+
+		// Cached by another replica
+		l2.SetWithSoftTTL("nytimes.com", "emissions", 5, 1*time.Millisecond, 1*time.Hour)
+		time.Sleep(5 * time.Millisecond)
+		value, revalidate, exists := store.GetWithRevalidation("nytimes.com")
+		assert.True(t, exists)
+		assert.True(t, revalidate)
+		assert.Equal(t, "emissions", value)
+
+		record, exists := l1.GetRecord("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, 5, record.Priority)
+		assert.WithinDuration(t, time.Now().Add(1*time.Hour), record.TTL, 1*time.Second)
+		_, revalidate, _ = store.GetWithRevalidation("nytimes.com")
+		assert.True(t, revalidate, "the soft TTL should be kept on promotion")
+		assert.Equal(t, TieredStoreStats{L1Hits: 1, L2Hits: 1, Promotions: 1}, store.Stats())
+	})
+
+	t.Run("with records looked up at once", func(t *testing.T) {
+		l1, l2, store := createTestTieredStore(0)
+
+		store.Set("nytimes.com", "emissions", 1, 1*time.Hour)
+		// Cached by another replica
+		l2.Set("cnn.com", "other emissions", 2, 1*time.Hour)
+		records := store.GetRecords([]string{"nytimes.com", "cnn.com", "bbc.com"})
+		assert.Len(t, records, 2)
+		assert.Equal(t, "emissions", records["nytimes.com"].Value)
+		assert.Equal(t, "other emissions", records["cnn.com"].Value)
+		_, exists := l1.GetRecord("cnn.com")
+		assert.True(t, exists, "cnn.com should be promoted into L1")
+		assert.Equal(t, TieredStoreStats{L1Hits: 1, L2Hits: 1, Misses: 1, Promotions: 1}, store.Stats())
+	})
+
+	t.Run("with L1 ttl", func(t *testing.T) {
+		l1, l2, store := createTestTieredStore(1 * time.Millisecond)
+
+		store.Set("nytimes.com", "emissions", 0, 1*time.Hour)
+		time.Sleep(5 * time.Millisecond)
+		// Refreshed by another replica
+		l2.Set("nytimes.com", "refreshed emissions", 0, 1*time.Hour)
+		_, exists := l1.Get("nytimes.com")
+		assert.False(t, exists)
+
+		value, exists := store.Get("nytimes.com")
+		assert.True(t, exists)
+		assert.Equal(t, "refreshed emissions", value)
+		assert.Equal(t, TieredStoreStats{L2Hits: 1, Promotions: 1}, store.Stats())
+	})
+
+	t.Run("with stale L1 and fresh L2", func(t *testing.T) {
+		l1, l2, store := createTestTieredStore(0)
+
+		l1.Set("nytimes.com", "emissions", 0, 1*time.Millisecond)
+		l2.Set("nytimes.com", "refreshed emissions", 0, 1*time.Hour)
+		time.Sleep(5 * time.Millisecond)
+		value, stale, exists := store.GetStale("nytimes.com")
+		assert.True(t, exists)
+		assert.False(t, stale)
+		assert.Equal(t, "refreshed emissions", value)
+	})
+
+	t.Run("with stale record in both tiers", func(t *testing.T) {
+		_, _, store := createTestTieredStore(0)
+
+		store.Set("nytimes.com", "emissions", 0, 1*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		value, stale, exists := store.GetStale("nytimes.com")
+		assert.True(t, exists)
+		assert.True(t, stale)
+		assert.Equal(t, "emissions", value)
+	})
+
+	t.Run("with miss", func(t *testing.T) {
+		_, _, store := createTestTieredStore(0)
+
+		_, exists := store.Get("nytimes.com")
+		assert.False(t, exists)
+		_, _, exists = store.GetStale("nytimes.com")
+		assert.False(t, exists)
+		assert.Equal(t, TieredStoreStats{Misses: 2}, store.Stats())
+	})
+}
+
+func createTestTieredStore(l1Ttl time.Duration) (*Cache, *Cache, *TieredStore) {
+	l1 := NewCache(Config{Capacity: 10, GracePeriod: 1 * time.Hour})
+	l2 := NewCache(Config{Capacity: 10, GracePeriod: 1 * time.Hour})
+	return l1, l2, NewTieredStore(TieredStoreConfig{L1: l1, L1Ttl: l1Ttl, L2: l2})
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"go.uber.org/zap"
+	v2 "scope3apiproxy/internal/scope3/v2"
+)
+
+// emissionFetch is an in-flight fetch of the emissions of a single cache key from scope3 server. Concurrent cache misses
+// on the same cache key wait for it instead of fetching the same emissions from scope3 server again.
+type emissionFetch struct {
+	done chan struct{}
+	// cacheKey is where the fetch is registered for the concurrent cache misses. It is empty when the fetch isn't shared.
+	cacheKey string
+	call     *emissionCall
+	// impressions are the impressions of the row fetched from scope3 server
+	impressions int
+	measured    v2.MeasureResult
+	// err is the error of the whole fetch (eg, scope3 server is down), unlike measured.Err which is the error of the row
+	err error
+}
+
+// emissionCall is a single call to scope3 server made for the fetches of several cache keys. It isn't bound to the
+// request that started it since other requests may wait for its fetches, so it is only cancelled once every request
+// waiting for it gave up.
+type emissionCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+	fetches []*emissionFetch
+}
+
+func newEmissionFetch(impressions int, call *emissionCall) *emissionFetch {
+	fetch := &emissionFetch{
+		done:        make(chan struct{}),
+		call:        call,
+		impressions: impressions,
+	}
+	call.fetches = append(call.fetches, fetch)
+	return fetch
+}
+
+// emissionsFor returns the fetched emissions scaled to the given impressions.
+func (f *emissionFetch) emissionsFor(impressions int) *v2.Breakdown {
+	if impressions == f.impressions {
+		return f.measured.EmissionsBreakdown
+	}
+	return f.measured.EmissionsBreakdown.Scale(float64(impressions) / float64(f.impressions))
+}
+
+// startFetches returns the fetch of each filter. The fetches already in flight are shared, while the others are fetched
+// in the background through a single call to scope3 server.
+// Filters without impressions are never shared since their emissions can't be scaled to the other filters.
+func (s *EmissionService) startFetches(ctx context.Context, filters []EmissionFilter) []*emissionFetch {
+	s.fetchingMutex.Lock()
+	defer s.fetchingMutex.Unlock()
+
+	var (
+		call           *emissionCall
+		startedFilters []EmissionFilter
+	)
+	fetches := make([]*emissionFetch, len(filters))
+	for i, filter := range filters {
+		cacheKey := s.cacheKeyFunc(filter)
+		if fetch, inFlight := s.fetching[cacheKey]; inFlight && filter.Impressions > 0 {
+			fetches[i] = fetch
+			continue
+		}
+		if call == nil {
+			callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			call = &emissionCall{ctx: callCtx, cancel: cancel}
+		}
+		fetches[i] = newEmissionFetch(filter.Impressions, call)
+		if filter.Impressions > 0 {
+			fetches[i].cacheKey = cacheKey
+			s.fetching[cacheKey] = fetches[i]
+		}
+		startedFilters = append(startedFilters, filter)
+	}
+
+	for call := range callsOf(fetches) {
+		call.waiters++
+	}
+	if call != nil {
+		go s.fetchEmissions(call, startedFilters)
+	}
+	return fetches
+}
+
+// leaveFetches is called by a request that gave up waiting for its fetches. The calls nobody waits for anymore are
+// cancelled, and their fetches aren't shared with the next cache misses since they won't complete.
+func (s *EmissionService) leaveFetches(fetches []*emissionFetch) {
+	s.fetchingMutex.Lock()
+	defer s.fetchingMutex.Unlock()
+
+	for call := range callsOf(fetches) {
+		call.waiters--
+		if call.waiters > 0 {
+			continue
+		}
+		call.cancel()
+		for _, fetch := range call.fetches {
+			if fetch.cacheKey != "" && s.fetching[fetch.cacheKey] == fetch {
+				delete(s.fetching, fetch.cacheKey)
+			}
+		}
+	}
+}
+
+// fetchEmissions fetches the emissions of the filters from scope3 server, caches them, then completes the fetches of
+// the call. The filters are in the same order as the fetches of the call.
+func (s *EmissionService) fetchEmissions(call *emissionCall, filters []EmissionFilter) {
+	defer call.cancel()
+
+	freshData, err := s.measure(call.ctx, filters)
+	if err == nil {
+		// freshData is in the same order as the filters
+		s.cacheEmissions(filters, freshData)
+	} else if isScope3SideError(err) && call.ctx.Err() == nil {
+		s.logger.Warn("Failed to fetch emissions breakdown from scope3 server.", zap.Error(err))
+	}
+
+	s.fetchingMutex.Lock()
+	defer s.fetchingMutex.Unlock()
+	for i, fetch := range call.fetches {
+		if err != nil {
+			fetch.err = err
+		} else {
+			fetch.measured = freshData[i]
+		}
+		if fetch.cacheKey != "" && s.fetching[fetch.cacheKey] == fetch {
+			delete(s.fetching, fetch.cacheKey)
+		}
+		close(fetch.done)
+	}
+}
+
+func callsOf(fetches []*emissionFetch) map[*emissionCall]bool {
+	calls := map[*emissionCall]bool{}
+	for _, fetch := range fetches {
+		calls[fetch.call] = true
+	}
+	return calls
+}
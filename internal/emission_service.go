@@ -1,21 +1,51 @@
 package internal
 
 import (
+	"context"
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"go.uber.org/zap"
 	"scope3apiproxy/internal/cache"
 	v2 "scope3apiproxy/internal/scope3/v2"
+	"strings"
+	"sync"
 	"time"
 )
 
 const EmissionCacheKeySuffix = "_emission"
+const EmissionCacheKeySeparator = "|"
+
+// EmissionImpressionsBasis is the number of impressions the cached emissions are normalized to. Emissions are scaled
+// from this basis to the impressions of each filter, so the same cached emissions answer any number of impressions.
+const EmissionImpressionsBasis = 1000
+
+func init() {
+	// The cached emissions are gob encoded by the stores shared across replicas (eg, redis)
+	gob.Register(&v2.Breakdown{})
+}
+
+// EmissionCacheKeyFunc builds the key used to cache the emissions of the given filter.
+type EmissionCacheKeyFunc func(filter EmissionFilter) string
 
 type EmissionService struct {
 	logger          *zap.Logger
-	scope3APIClient *v2.Scope3APIClient
-	cache           *cache.Cache
+	scope3APIClient v2.MeasureAPI
+	cache           cache.Store
 	cacheTtl        time.Duration
+	// cacheSoftTtl is when the cached emissions are refreshed in the background while still being served
+	cacheSoftTtl time.Duration
+	cacheKeyFunc EmissionCacheKeyFunc
+	// revalidating are the cache keys being refreshed in the background
+	revalidating      map[string]bool
+	revalidatingMutex sync.Mutex
+	// fetching are the fetches from scope3 server in flight, keyed by cache key
+	fetching      map[string]*emissionFetch
+	fetchingMutex sync.Mutex
+	// staleFilters are the filters whose emissions were served as stale, keyed by cache key. They are refreshed by
+	// RefreshStaleEmissions once scope3 server recovers.
+	staleFilters      map[string]EmissionFilter
+	staleFiltersMutex sync.Mutex
 }
 
 type EmissionFilter struct {
@@ -25,69 +55,445 @@ type EmissionFilter struct {
 	Impressions int
 	UtcDatetime string
 	Priority    int
+	// Options are how the emissions are measured by scope3 server. Not set means the default view.
+	Options v2.MeasureOptions
 }
 
 func NewEmissionService(
 	logger *zap.Logger,
-	scope3APIClient *v2.Scope3APIClient,
-	cache *cache.Cache,
+	scope3APIClient v2.MeasureAPI,
+	cache cache.Store,
 	cacheTtl time.Duration,
+	cacheSoftTtl time.Duration,
 ) *EmissionService {
+	if cacheSoftTtl <= 0 || cacheSoftTtl > cacheTtl {
+		cacheSoftTtl = cacheTtl
+	}
 	return &EmissionService{
 		logger:          logger,
 		scope3APIClient: scope3APIClient,
 		cache:           cache,
 		cacheTtl:        cacheTtl,
+		cacheSoftTtl:    cacheSoftTtl,
+		cacheKeyFunc:    DefaultEmissionCacheKey,
+		revalidating:    map[string]bool{},
+		fetching:        map[string]*emissionFetch{},
+		staleFilters:    map[string]EmissionFilter{},
 	}
 }
 
-type EmissionPerProperty map[string]interface{}
+// SetCacheKeyFunc replaces the function used to build the cache key of each filter (eg, to add more dimensions).
+func (s *EmissionService) SetCacheKeyFunc(cacheKeyFunc EmissionCacheKeyFunc) {
+	s.cacheKeyFunc = cacheKeyFunc
+}
+
+// DefaultEmissionCacheKey builds the cache key from the inventory id, country, channel, utc datetime and measure options
+// of the filter. Impressions are not part of the key.
+func DefaultEmissionCacheKey(filter EmissionFilter) string {
+	parts := []string{
+		filter.InventoryId,
+		filter.Country,
+		filter.Channel,
+		filter.UtcDatetime,
+	}
+	// The default options are left out so that the keys of the default view are the same as before the options existed
+	if optionsKey := filter.Options.Key(); optionsKey != "" {
+		parts = append(parts, optionsKey)
+	}
+	return strings.Join(parts, EmissionCacheKeySeparator) + EmissionCacheKeySuffix
+}
 
-func (s *EmissionService) GetEmissions(filters []EmissionFilter) (*EmissionPerProperty, error) {
-	result := EmissionPerProperty{}
-	propertyPriorityMap := map[string]int{}
+// Emission is the emissions of a single EmissionFilter. Error is set instead of the emissions when they can't be fetched.
+// Stale is true when the emissions come from an expired cache record because scope3 server is unavailable.
+// Pending is true when scope3 server didn't answer within the latency budget. The emissions are still being fetched in
+// the background, so they are cached for the next request.
+type Emission struct {
+	Filter    EmissionFilter
+	Emissions *v2.Breakdown
+	Stale     bool
+	Pending   bool
+	Error     string
+}
 
-	var toFetchFromScope3 []v2.MeasureFilterRow
-	for _, filter := range filters {
-		propertyName := filter.InventoryId
-		if emissions, exists := s.cache.Get(propertyName + EmissionCacheKeySuffix); exists {
-			result[propertyName] = emissions
+// EmissionUnavailableError is the error of the emissions that are neither in cache nor fetched from scope3 server.
+const EmissionUnavailableError = "emissions are temporarily unavailable"
+
+// EmissionInvalidImpressionsError is the error of the filters with less than 1 impression, whose emissions can't be
+// scaled from the cached ones.
+const EmissionInvalidImpressionsError = "impressions must be at least 1"
+
+// GetEmissions returns the emissions of each filter in the same order as the given filters. It gives up waiting for
+// scope3 server once the context is done.
+func (s *EmissionService) GetEmissions(ctx context.Context, filters []EmissionFilter) ([]Emission, error) {
+	return s.GetEmissionsWithinBudget(ctx, filters, 0)
+}
+
+// GetEmissionsWithinBudget is GetEmissions, except that it stops waiting for scope3 server once the latency budget is
+// over. The emissions not fetched by then are Pending. There is no latency budget when it is not set.
+func (s *EmissionService) GetEmissionsWithinBudget(
+	ctx context.Context,
+	filters []EmissionFilter,
+	latencyBudget time.Duration,
+) ([]Emission, error) {
+	result := make([]Emission, len(filters))
+
+	var (
+		filtersToFetch []EmissionFilter
+		indexesToFetch []int
+		toRevalidate   []EmissionFilter
+	)
+	// Looked up at once, since each lookup is a round trip when the cache is shared (eg, redis)
+	cacheKeys := make([]string, len(filters))
+	lookupKeys := make([]string, 0, len(filters))
+	for i, filter := range filters {
+		cacheKeys[i] = s.cacheKeyFunc(filter)
+		if filter.Impressions >= 1 {
+			lookupKeys = append(lookupKeys, cacheKeys[i])
+		}
+	}
+	records := s.cache.GetRecords(lookupKeys)
+	now := time.Now()
+	for i, filter := range filters {
+		result[i].Filter = filter
+		if filter.Impressions < 1 {
+			// Rejected the same way whether the filter is cached or not, instead of zero or negative emissions
+			result[i].Error = EmissionInvalidImpressionsError
+			continue
+		}
+		record, exists := records[cacheKeys[i]]
+		if emissions, ok := record.Value.(*v2.Breakdown); exists && ok && !now.After(record.TTL) {
+			result[i].Emissions = emissions.Scale(float64(filter.Impressions) / EmissionImpressionsBasis)
+			if now.After(record.SoftTTL) {
+				toRevalidate = append(toRevalidate, filter)
+			}
 		} else {
-			toFetchFromScope3 = append(toFetchFromScope3, v2.MeasureFilterRow{
-				Country:     filter.Country,
-				Channel:     filter.Channel,
-				InventoryId: filter.InventoryId,
-				Impressions: filter.Impressions,
-				UtcDatetime: filter.UtcDatetime,
-			})
-			propertyPriorityMap[filter.InventoryId] = filter.Priority
+			filtersToFetch = append(filtersToFetch, filter)
+			indexesToFetch = append(indexesToFetch, i)
 		}
 	}
+	if len(toRevalidate) > 0 {
+		// The refresh outlives the request, so it isn't cancelled along with it
+		s.revalidateInBackground(context.WithoutCancel(ctx), toRevalidate)
+	}
+	if len(filtersToFetch) == 0 {
+		return result, nil
+	}
 
-	if len(toFetchFromScope3) > 0 {
-		freshData, err := s.scope3APIClient.GetEmissionsBreakdown(toFetchFromScope3)
-		if err != nil {
-			var serverError v2.Scope3ServerError
-			if errors.As(err, &serverError) {
-				// For any scope3 specific api server error (eg, server is down), the app will return whatever is in cache
-				s.logger.Warn("Failed to fetch emissions breakdown from scope3 server.", zap.Error(err))
-			} else {
-				// might be application error
-				return nil, fmt.Errorf("failed to fetch emissions breakdown from scope3 server: %w", err)
+	// Cache misses already being fetched by concurrent requests share the same fetch from scope3 server
+	fetches := s.startFetches(ctx, filtersToFetch)
+	var budgetOver <-chan time.Time
+	if latencyBudget > 0 {
+		budgetTimer := time.NewTimer(latencyBudget)
+		defer budgetTimer.Stop()
+		budgetOver = budgetTimer.C
+	}
+	pending := make([]bool, len(fetches))
+	waiting := true
+	for j, fetch := range fetches {
+		if waiting {
+			select {
+			case <-ctx.Done():
+				s.leaveFetches(fetches)
+				return nil, fmt.Errorf("gave up waiting for emissions breakdown from scope3 server: %w", ctx.Err())
+			case <-budgetOver:
+				// The fetches aren't left so that they complete in the background and get cached for the next request
+				waiting = false
+			case <-fetch.done:
 			}
-		} else {
-			for propertyName, emissions := range freshData {
-				go func() {
-					s.cache.Set(
-						propertyName+EmissionCacheKeySuffix,
-						emissions,
-						propertyPriorityMap[propertyName],
-						s.cacheTtl,
-					)
-				}()
-				result[propertyName] = emissions
+		}
+		if !waiting {
+			// Whatever is fetched by the end of the latency budget is still part of the result
+			select {
+			case <-fetch.done:
+			default:
+				pending[j] = true
+			}
+		}
+	}
+
+	// upstreamError is the error on scope3 side that made the emissions fall back to the cache
+	var upstreamError error
+	for j, fetch := range fetches {
+		i := indexesToFetch[j]
+		if pending[j] {
+			result[i].Pending = true
+			continue
+		}
+		if fetch.err != nil {
+			if isScope3SideError(fetch.err) {
+				// For any error on scope3 side (eg, server is down), the app will return whatever is in cache,
+				// including the records that have expired but are still within the cache grace period
+				upstreamError = fetch.err
+				s.setStaleEmissions(&result[i], cacheKeys[i], records)
+				continue
+			}
+			// might be application error or bad request
+			return nil, fmt.Errorf("failed to fetch emissions breakdown from scope3 server: %w", fetch.err)
+		}
+		if fetch.measured.Err != nil {
+			if isScope3SideError(fetch.measured.Err) {
+				// Only the chunk of rows where this row belongs failed
+				upstreamError = fetch.measured.Err
+				s.setStaleEmissions(&result[i], cacheKeys[i], records)
+				continue
+			}
+			result[i].Error = s.rowErrorMessage(fetch.measured.Err)
+			continue
+		}
+		result[i].Emissions = fetch.emissionsFor(result[i].Filter.Impressions)
+	}
+
+	if upstreamError != nil {
+		// Nothing to answer with, so the caller gets the error on scope3 side instead of a result full of errors
+		for _, emission := range result {
+			if emission.Emissions != nil || emission.Pending {
+				return result, nil
 			}
 		}
+		return nil, fmt.Errorf("failed to fetch emissions breakdown from scope3 server: %w", upstreamError)
+	}
+	return result, nil
+}
+
+// RefreshStaleEmissions periodically fetches the emissions that were served as stale until they are cached again.
+// It blocks until the given context is done. Nothing is refreshed when the interval is not set.
+func (s *EmissionService) RefreshStaleEmissions(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshStaleEmissions(ctx)
+		}
+	}
+}
+
+func (s *EmissionService) refreshStaleEmissions(ctx context.Context) {
+	s.staleFiltersMutex.Lock()
+	cacheKeys := make([]string, 0, len(s.staleFilters))
+	filters := make([]EmissionFilter, 0, len(s.staleFilters))
+	for cacheKey, filter := range s.staleFilters {
+		cacheKeys = append(cacheKeys, cacheKey)
+		filters = append(filters, filter)
+	}
+	s.staleFiltersMutex.Unlock()
+	if len(filters) == 0 {
+		return
+	}
+
+	freshData, err := s.measure(ctx, filters)
+	if err != nil {
+		// Keep the stale filters to try again on the next refresh
+		s.logger.Debug("Unable to refresh stale emissions from scope3 server.", zap.Error(err))
+		return
+	}
+
+	s.cacheEmissions(filters, freshData)
+	s.staleFiltersMutex.Lock()
+	defer s.staleFiltersMutex.Unlock()
+	refreshed := 0
+	for i, measured := range freshData {
+		if isScope3SideError(measured.Err) {
+			// The chunk of rows where this row belongs failed, so try again on the next refresh
+			continue
+		}
+		if measured.Err == nil {
+			refreshed++
+		}
+		// Rows rejected by scope3 server won't succeed on the next refresh either
+		delete(s.staleFilters, cacheKeys[i])
+	}
+	s.logger.Info(fmt.Sprintf("Refreshed %d stale emissions from scope3 server.", refreshed))
+}
+
+// revalidateInBackground refreshes the cached emissions of the filters asynchronously. Filters already being refreshed
+// are skipped, so there is at most one refresh per cache key in flight.
+func (s *EmissionService) revalidateInBackground(ctx context.Context, filters []EmissionFilter) {
+	var (
+		toRefresh []EmissionFilter
+		cacheKeys []string
+	)
+	s.revalidatingMutex.Lock()
+	for _, filter := range filters {
+		cacheKey := s.cacheKeyFunc(filter)
+		if s.revalidating[cacheKey] {
+			continue
+		}
+		s.revalidating[cacheKey] = true
+		toRefresh = append(toRefresh, filter)
+		cacheKeys = append(cacheKeys, cacheKey)
+	}
+	s.revalidatingMutex.Unlock()
+	if len(toRefresh) == 0 {
+		return
+	}
+
+	go func() {
+		defer func() {
+			s.revalidatingMutex.Lock()
+			for _, cacheKey := range cacheKeys {
+				delete(s.revalidating, cacheKey)
+			}
+			s.revalidatingMutex.Unlock()
+		}()
+		freshData, err := s.measure(ctx, toRefresh)
+		if err != nil {
+			// The cached emissions are still served until they expire, so the next request will try again
+			s.logger.Warn("Unable to revalidate emissions from scope3 server.", zap.Error(err))
+			return
+		}
+		s.cacheEmissions(toRefresh, freshData)
+	}()
+}
+
+// setStaleEmissions sets the emissions from the records looked up in the cache, even if expired, otherwise sets
+// EmissionUnavailableError.
+func (s *EmissionService) setStaleEmissions(emission *Emission, cacheKey string, records map[string]cache.Record) {
+	record, exists := records[cacheKey]
+	emissions, ok := record.Value.(*v2.Breakdown)
+	if !exists || !ok {
+		emission.Error = EmissionUnavailableError
+		return
+	}
+	emission.Emissions = emissions.Scale(float64(emission.Filter.Impressions) / EmissionImpressionsBasis)
+	stale := time.Now().After(record.TTL)
+	emission.Stale = stale
+	if stale {
+		s.staleFiltersMutex.Lock()
+		s.staleFilters[cacheKey] = emission.Filter
+		s.staleFiltersMutex.Unlock()
+	}
+}
+
+// cacheEmissions caches the emissions of the filters measured without error, normalized to EmissionImpressionsBasis.
+// The measured rows are in the same order as the filters, and they are cached at once.
+func (s *EmissionService) cacheEmissions(filters []EmissionFilter, freshData []v2.MeasureResult) {
+	now := time.Now()
+	records := make([]cache.Record, 0, len(freshData))
+	for i, measured := range freshData {
+		// Impressions below 1 are rejected by scope3, but it is best to not cache emissions that can't be normalized
+		if measured.Err != nil || filters[i].Impressions <= 0 {
+			continue
+		}
+		records = append(records, cache.Record{
+			Key:      s.cacheKeyFunc(filters[i]),
+			Value:    measured.EmissionsBreakdown.Scale(EmissionImpressionsBasis / float64(filters[i].Impressions)),
+			Priority: filters[i].Priority,
+			SoftTTL:  now.Add(s.cacheSoftTtl),
+			TTL:      now.Add(s.cacheTtl),
+		})
+	}
+	if len(records) > 0 {
+		s.cache.SetRecords(records)
+	}
+}
+
+// measure fetches the emissions of the filters from scope3 server in the same order as the filters. Filters with
+// different measure options are fetched through separate calls, where a failed call only fails its filters through
+// v2.MeasureResult.Err, unless every call failed.
+func (s *EmissionService) measure(ctx context.Context, filters []EmissionFilter) ([]v2.MeasureResult, error) {
+	var (
+		optionsKeys []string
+		indexes     = map[string][]int{}
+	)
+	for i, filter := range filters {
+		optionsKey := filter.Options.Key()
+		if _, exists := indexes[optionsKey]; !exists {
+			optionsKeys = append(optionsKeys, optionsKey)
+		}
+		indexes[optionsKey] = append(indexes[optionsKey], i)
+	}
+	if len(optionsKeys) == 1 {
+		return s.scope3APIClient.GetEmissionsBreakdown(ctx, toMeasureFilterRows(filters), filters[0].Options)
+	}
+
+	result := make([]v2.MeasureResult, len(filters))
+	var (
+		firstErr error
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+	)
+	succeeded := false
+	for _, optionsKey := range optionsKeys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group := make([]EmissionFilter, 0, len(indexes[optionsKey]))
+			for _, i := range indexes[optionsKey] {
+				group = append(group, filters[i])
+			}
+			freshData, err := s.scope3APIClient.GetEmissionsBreakdown(ctx, toMeasureFilterRows(group), group[0].Options)
+			mutex.Lock()
+			defer mutex.Unlock()
+			for j, i := range indexes[optionsKey] {
+				if err != nil {
+					result[i] = v2.MeasureResult{Err: err}
+				} else {
+					result[i] = freshData[j]
+				}
+			}
+			if err == nil {
+				succeeded = true
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	if !succeeded {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+func toMeasureFilterRows(filters []EmissionFilter) []v2.MeasureFilterRow {
+	rows := make([]v2.MeasureFilterRow, 0, len(filters))
+	for _, filter := range filters {
+		rows = append(rows, toMeasureFilterRow(filter))
+	}
+	return rows
+}
+
+func toMeasureFilterRow(filter EmissionFilter) v2.MeasureFilterRow {
+	return v2.MeasureFilterRow{
+		Country:     filter.Country,
+		Channel:     filter.Channel,
+		InventoryId: filter.InventoryId,
+		Impressions: filter.Impressions,
+		UtcDatetime: filter.UtcDatetime,
+		Priority:    filter.Priority,
+	}
+}
+
+// isScope3SideError checks whether the error is caused by scope3 server rather than the request. On these errors, the
+// cached emissions, even if stale, are still the best answer.
+func isScope3SideError(err error) bool {
+	var (
+		serverError          v2.Scope3ServerError
+		rateLimitedError     v2.Scope3RateLimitedError
+		overloadedError      v2.Scope3OverloadedError
+		unauthorizedError    v2.Scope3UnauthorizedError
+		invalidResponseError v2.Scope3InvalidResponseError
+	)
+	return errors.As(err, &serverError) ||
+		errors.As(err, &rateLimitedError) ||
+		errors.As(err, &overloadedError) ||
+		errors.As(err, &unauthorizedError) ||
+		errors.As(err, &invalidResponseError)
+}
+
+// rowErrorMessage returns the message of the row error from scope3 server as is, since it is meant for the client.
+// Any other error is logged instead of being sent to the client.
+func (s *EmissionService) rowErrorMessage(err error) string {
+	var rowError v2.Scope3RowError
+	if errors.As(err, &rowError) {
+		return rowError.Message
 	}
-	return &result, nil
+	s.logger.Error("Failed to fetch emissions breakdown of a row from scope3 server.", zap.Error(err))
+	return EmissionUnavailableError
 }
@@ -0,0 +1,236 @@
+package v2
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ApiKeySelection string
+
+const (
+	// ApiKeyRoundRobin uses the keys one after the other
+	ApiKeyRoundRobin ApiKeySelection = "round-robin"
+	// ApiKeyLeastUsed uses the key with the fewest calls in flight, then the fewest calls overall
+	ApiKeyLeastUsed ApiKeySelection = "least-used"
+)
+
+// DefaultApiKeyQuarantine is how long a key rejected by scope3 server is not used when not configured.
+const DefaultApiKeyQuarantine = 1 * time.Minute
+
+type ApiKeyPoolConfig struct {
+	Keys []string
+	// Selection is how the key of each call is selected. Defaults to ApiKeyRoundRobin.
+	Selection ApiKeySelection
+	// Quarantine is how long a key is not used once scope3 server rejects it with HTTP 401, 403 or 429. Retry-After of
+	// HTTP 429 takes precedence. Defaults to DefaultApiKeyQuarantine.
+	Quarantine time.Duration
+}
+
+// ApiKeyPool spreads the calls to scope3 server across several api keys (eg, of different accounts). Keys rejected by
+// scope3 server are quarantined for a while, and the keys can be replaced at runtime through SetKeys.
+type ApiKeyPool struct {
+	keys       []*apiKey
+	next       int
+	selection  ApiKeySelection
+	quarantine time.Duration
+	mutex      sync.Mutex
+}
+
+type apiKey struct {
+	value            string
+	inFlight         int
+	calls            int64
+	quarantinedUntil time.Time
+	// rejectedWith is the HTTP status that quarantined the key
+	rejectedWith int
+}
+
+// ApiKeyStats is the health of a single key. The key itself is masked.
+type ApiKeyStats struct {
+	Key              string     `json:"key"`
+	InFlight         int        `json:"inFlight"`
+	Calls            int64      `json:"calls"`
+	QuarantinedUntil *time.Time `json:"quarantinedUntil,omitempty"`
+	RejectedWith     int        `json:"rejectedWith,omitempty"`
+}
+
+func NewApiKeyPool(config ApiKeyPoolConfig) *ApiKeyPool {
+	if config.Selection == "" {
+		config.Selection = ApiKeyRoundRobin
+	}
+	if config.Quarantine <= 0 {
+		config.Quarantine = DefaultApiKeyQuarantine
+	}
+	pool := &ApiKeyPool{
+		selection:  config.Selection,
+		quarantine: config.Quarantine,
+	}
+	pool.SetKeys(config.Keys)
+	return pool
+}
+
+// SetKeys replaces the keys of the pool. The keys already in the pool keep their health (eg, quarantine), while the
+// calls in flight with a removed key complete as is.
+func (p *ApiKeyPool) SetKeys(keys []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	existingKeys := make(map[string]*apiKey, len(p.keys))
+	for _, key := range p.keys {
+		existingKeys[key.value] = key
+	}
+	added := make(map[string]bool, len(keys))
+	p.keys = make([]*apiKey, 0, len(keys))
+	for _, value := range keys {
+		if value == "" || added[value] {
+			continue
+		}
+		added[value] = true
+		key, exists := existingKeys[value]
+		if !exists {
+			key = &apiKey{value: value}
+		}
+		p.keys = append(p.keys, key)
+	}
+	p.next = 0
+}
+
+// checkAvailable fails when every key is quarantined, with the error of the key that gets out of quarantine first.
+func (p *ApiKeyPool) checkAvailable() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	var soonest *apiKey
+	for _, key := range p.keys {
+		if !now.Before(key.quarantinedUntil) {
+			return nil
+		}
+		if soonest == nil || key.quarantinedUntil.Before(soonest.quarantinedUntil) {
+			soonest = key
+		}
+	}
+	if soonest == nil {
+		// No key is configured, so the calls are made without any
+		return nil
+	}
+	if soonest.rejectedWith == http.StatusTooManyRequests {
+		return Scope3RateLimitedError{
+			Message:    "Every scope3 api key is rate limited",
+			RetryAfter: soonest.quarantinedUntil.Sub(now),
+		}
+	}
+	return Scope3UnauthorizedError{
+		Message:    "Every scope3 api key is rejected by scope3 server",
+		StatusCode: soonest.rejectedWith,
+	}
+}
+
+// hasHealthyKey checks whether any key is out of quarantine. It is false when no key is configured.
+func (p *ApiKeyPool) hasHealthyKey() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range p.keys {
+		if !now.Before(key.quarantinedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire selects the key of a call, which must be followed by release with the response of the call. When every key is
+// quarantined, the one that gets out of quarantine first is used anyway. It returns nil when no key is configured.
+func (p *ApiKeyPool) acquire() *apiKey {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.keys) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var selected *apiKey
+	for i := range p.keys {
+		key := p.keys[(p.next+i)%len(p.keys)]
+		if now.Before(key.quarantinedUntil) {
+			continue
+		}
+		if p.selection == ApiKeyRoundRobin {
+			selected = key
+			p.next = (p.next + i + 1) % len(p.keys)
+			break
+		}
+		if selected == nil || key.inFlight < selected.inFlight ||
+			(key.inFlight == selected.inFlight && key.calls < selected.calls) {
+			selected = key
+		}
+	}
+	if selected == nil {
+		for _, key := range p.keys {
+			if selected == nil || key.quarantinedUntil.Before(selected.quarantinedUntil) {
+				selected = key
+			}
+		}
+	}
+	selected.inFlight++
+	selected.calls++
+	return selected
+}
+
+// release quarantines the key when scope3 server rejects it. resp is nil when the call failed without a response.
+func (p *ApiKeyPool) release(key *apiKey, resp *http.Response) {
+	if key == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key.inFlight--
+	if resp == nil {
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		key.quarantinedUntil = time.Now().Add(p.quarantine)
+		key.rejectedWith = resp.StatusCode
+	case http.StatusTooManyRequests:
+		quarantine := p.quarantine
+		if retryAfter, exists := parseRetryAfter(resp.Header.Get("Retry-After")); exists {
+			quarantine = retryAfter
+		}
+		key.quarantinedUntil = time.Now().Add(quarantine)
+		key.rejectedWith = resp.StatusCode
+	}
+}
+
+func (p *ApiKeyPool) Stats() []ApiKeyStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	stats := make([]ApiKeyStats, 0, len(p.keys))
+	for _, key := range p.keys {
+		keyStats := ApiKeyStats{
+			Key:      maskApiKey(key.value),
+			InFlight: key.inFlight,
+			Calls:    key.calls,
+		}
+		if now.Before(key.quarantinedUntil) {
+			quarantinedUntil := key.quarantinedUntil
+			keyStats.QuarantinedUntil = &quarantinedUntil
+			keyStats.RejectedWith = key.rejectedWith
+		}
+		stats = append(stats, keyStats)
+	}
+	return stats
+}
+
+// maskApiKey only keeps the last 4 characters of the key so that it can be told apart without being leaked.
+func maskApiKey(value string) string {
+	if len(value) <= 4 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
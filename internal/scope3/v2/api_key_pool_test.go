@@ -0,0 +1,126 @@
+package v2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApiKeyPool(t *testing.T) {
+	t.Run("with round robin", func(t *testing.T) {
+		var keysUsed []string
+		scope3MockAPIServer := createMockHttpServerRejectingKeys(&keysUsed, nil)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithApiKeys(scope3MockAPIServer.URL, ApiKeyRoundRobin, "key1", "key2")
+		for range 3 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []string{"key1", "key2", "key1"}, keysUsed)
+	})
+
+	t.Run("with least used", func(t *testing.T) {
+		pool := NewApiKeyPool(ApiKeyPoolConfig{Keys: []string{"key1", "key2"}, Selection: ApiKeyLeastUsed})
+		first := pool.acquire()
+		// The first key is still in flight
+		assert.Equal(t, "key2", pool.acquire().value)
+		pool.release(first, nil)
+		assert.Equal(t, "key1", pool.acquire().value)
+	})
+
+	t.Run("with rejected key", func(t *testing.T) {
+		var keysUsed []string
+		scope3MockAPIServer := createMockHttpServerRejectingKeys(&keysUsed, map[string]int{"key1": http.StatusUnauthorized})
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithApiKeys(scope3MockAPIServer.URL, ApiKeyRoundRobin, "key1", "key2")
+		for range 2 {
+			// The call rejected with key1 goes to key2 right away
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []string{"key1", "key2", "key2"}, keysUsed)
+
+		stats := scope3APIClient.ApiKeyPool().Stats()
+		assert.NotNil(t, stats[0].QuarantinedUntil)
+		assert.Equal(t, http.StatusUnauthorized, stats[0].RejectedWith)
+		assert.Nil(t, stats[1].QuarantinedUntil)
+	})
+
+	t.Run("with every key rejected", func(t *testing.T) {
+		var keysUsed []string
+		scope3MockAPIServer := createMockHttpServerRejectingKeys(&keysUsed, map[string]int{
+			"key1": http.StatusUnauthorized,
+			"key2": http.StatusForbidden,
+		})
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithApiKeys(scope3MockAPIServer.URL, ApiKeyRoundRobin, "key1", "key2")
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.ErrorAs(t, err, &Scope3UnauthorizedError{})
+		// Each key is tried once
+		assert.Equal(t, []string{"key1", "key2"}, keysUsed)
+	})
+
+	t.Run("with every key rate limited", func(t *testing.T) {
+		var keysUsed []string
+		scope3MockAPIServer := createMockHttpServerRejectingKeys(&keysUsed, map[string]int{"key1": http.StatusTooManyRequests})
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithApiKeys(scope3MockAPIServer.URL, ApiKeyRoundRobin, "key1")
+		for range 2 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.ErrorAs(t, err, &Scope3RateLimitedError{})
+		}
+		// The second call fails without calling scope3 server
+		assert.Equal(t, []string{"key1"}, keysUsed)
+	})
+
+	t.Run("with reloaded keys", func(t *testing.T) {
+		var keysUsed []string
+		scope3MockAPIServer := createMockHttpServerRejectingKeys(&keysUsed, nil)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithApiKeys(scope3MockAPIServer.URL, ApiKeyRoundRobin, "key1")
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		scope3APIClient.ApiKeyPool().SetKeys([]string{"key2"})
+		_, err = scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"key1", "key2"}, keysUsed)
+	})
+}
+
+// createMockHttpServerRejectingKeys creates a scope3 API server mock that records the api key of each call, and rejects
+// the given keys with their HTTP status.
+func createMockHttpServerRejectingKeys(keysUsed *[]string, rejectedKeys map[string]int) *httptest.Server {
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Authorization")[len("Bearer "):]
+		mutex.Lock()
+		*keysUsed = append(*keysUsed, key)
+		mutex.Unlock()
+		if status, rejected := rejectedKeys[key]; rejected {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(measureResponseBody))
+	}))
+}
+
+func createTestScope3APIClientWithApiKeys(mockServerHost string, selection ApiKeySelection, keys ...string) *Scope3APIClient {
+	return NewScope3APIClient(Scope3APIClientConfig{
+		Host: mockServerHost,
+		ApiKeyPool: ApiKeyPoolConfig{
+			Keys:       keys,
+			Selection:  selection,
+			Quarantine: 1 * time.Hour,
+		},
+	})
+}
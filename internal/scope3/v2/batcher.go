@@ -0,0 +1,137 @@
+package v2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MeasureAPI fetches the emissions breakdown of the rows from scope3 measure api. It is implemented by both
+// Scope3APIClient and MeasureBatcher.
+type MeasureAPI interface {
+	GetEmissionsBreakdown(ctx context.Context, rows []MeasureFilterRow, options MeasureOptions) ([]MeasureResult, error)
+}
+
+// MeasureBatcher collects the rows of concurrent callers into a single call to scope3 measure api, then fans the result
+// of each row back out to its caller. Only the rows measured with the same options are batched together.
+type MeasureBatcher struct {
+	measureAPI MeasureAPI
+	window     time.Duration
+	maxRows    int
+	// pending are the batches waiting for more rows, keyed by MeasureOptions.Key
+	pending map[string]*measureBatch
+	mutex   sync.Mutex
+}
+
+type MeasureBatcherConfig struct {
+	// Window is how long the first rows of a batch wait for the rows of other callers before the batch is sent.
+	// Batching is disabled when it is not set.
+	Window time.Duration
+	// MaxRows sends the batch right away once it has this many rows. There is no limit when it is not set.
+	MaxRows int
+}
+
+type measureBatch struct {
+	key     string
+	rows    []MeasureFilterRow
+	options MeasureOptions
+	// ctx is cancelled once every caller of the batch gave up, since nobody needs the result anymore
+	ctx     context.Context
+	cancel  context.CancelFunc
+	callers int
+	timer   *time.Timer
+	done    chan struct{}
+	results []MeasureResult
+	err     error
+}
+
+func NewMeasureBatcher(measureAPI MeasureAPI, config MeasureBatcherConfig) *MeasureBatcher {
+	return &MeasureBatcher{
+		measureAPI: measureAPI,
+		window:     config.Window,
+		maxRows:    config.MaxRows,
+		pending:    map[string]*measureBatch{},
+	}
+}
+
+// GetEmissionsBreakdown adds the rows to the pending batch then waits for the batch to be sent. The result of each row is
+// returned in the same order as the given rows, same as Scope3APIClient.GetEmissionsBreakdown.
+func (b *MeasureBatcher) GetEmissionsBreakdown(
+	ctx context.Context,
+	rows []MeasureFilterRow,
+	options MeasureOptions,
+) ([]MeasureResult, error) {
+	if b.window <= 0 {
+		return b.measureAPI.GetEmissionsBreakdown(ctx, rows, options)
+	}
+
+	key := options.Key()
+	b.mutex.Lock()
+	batch := b.pending[key]
+	if batch == nil {
+		// The batch outlives the caller that creates it, so it isn't bound to the context of that caller
+		batchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		batch = &measureBatch{key: key, options: options, ctx: batchCtx, cancel: cancel, done: make(chan struct{})}
+		batch.timer = time.AfterFunc(b.window, func() {
+			b.flush(batch)
+		})
+		b.pending[key] = batch
+	}
+	batch.callers++
+	offset := len(batch.rows)
+	batch.rows = append(batch.rows, rows...)
+	full := b.maxRows > 0 && len(batch.rows) >= b.maxRows
+	if full {
+		// Any row after this goes to the next batch
+		delete(b.pending, key)
+		batch.timer.Stop()
+	}
+	b.mutex.Unlock()
+
+	if full {
+		go b.send(batch)
+	}
+	select {
+	case <-ctx.Done():
+		b.leave(batch)
+		return nil, Scope3ServerError{Message: "Gave up waiting for scope3 measure api", Err: ctx.Err()}
+	case <-batch.done:
+		b.leave(batch)
+	}
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	return batch.results[offset : offset+len(rows)], nil
+}
+
+// leave cancels the batch once its last caller is gone. A batch still pending is dropped first, so that the next callers
+// start a new batch instead of joining the cancelled one.
+func (b *MeasureBatcher) leave(batch *measureBatch) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batch.callers--
+	if batch.callers == 0 {
+		if b.pending[batch.key] == batch {
+			delete(b.pending, batch.key)
+			batch.timer.Stop()
+		}
+		batch.cancel()
+	}
+}
+
+// flush sends the batch once its window is over, unless it was already sent because it was full.
+func (b *MeasureBatcher) flush(batch *measureBatch) {
+	b.mutex.Lock()
+	if b.pending[batch.key] != batch {
+		b.mutex.Unlock()
+		return
+	}
+	delete(b.pending, batch.key)
+	b.mutex.Unlock()
+	b.send(batch)
+}
+
+func (b *MeasureBatcher) send(batch *measureBatch) {
+	batch.results, batch.err = b.measureAPI.GetEmissionsBreakdown(batch.ctx, batch.rows, batch.options)
+	close(batch.done)
+}
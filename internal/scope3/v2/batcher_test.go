@@ -0,0 +1,27 @@
+package v2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMeasureBatcher(t *testing.T) {
+	t.Run("with every caller of the batch gone", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(measureResponseBody)
+		defer scope3MockAPIServer.Close()
+		measureBatcher := NewMeasureBatcher(createTestScope3APIClient(scope3MockAPIServer.URL, 1),
+			MeasureBatcherConfig{Window: 50 * time.Millisecond})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		_, err := measureBatcher.GetEmissionsBreakdown(ctx, measureRows, MeasureOptions{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// The next caller starts a new batch instead of joining the cancelled one
+		result, err := measureBatcher.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "nytimes.com", result[0].PropertyName)
+	})
+}
@@ -0,0 +1,169 @@
+package v2
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling scope3 server while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("scope3 circuit breaker is open")
+
+type CircuitBreakerState int
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitBreakerState = iota
+	// CircuitOpen fails every call right away until the open timeout passes
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial call through at a time to check whether scope3 server has recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is how many consecutive failed calls open the circuit. The circuit breaker is disabled when it is
+	// not set.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before letting trial calls through
+	OpenTimeout time.Duration
+	// SuccessThreshold is how many consecutive successful trial calls close the circuit again. Defaults to 1.
+	SuccessThreshold int
+	// OnStateChange is called on every state transition (eg, for logging)
+	OnStateChange func(from CircuitBreakerState, to CircuitBreakerState)
+}
+
+// CircuitBreaker stops calling scope3 server once it keeps failing so that callers fail fast instead of waiting for the
+// timeout of each call.
+type CircuitBreaker struct {
+	config               CircuitBreakerConfig
+	state                CircuitBreakerState
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	openedAt             time.Time
+	trialInFlight        bool
+	mutex                sync.Mutex
+}
+
+// CircuitBreakerStats is the current state of the circuit breaker.
+type CircuitBreakerStats struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = 1
+	}
+	return &CircuitBreaker{config: config}
+}
+
+// allow checks whether a call can be made. Every allowed call must be followed by record with its outcome, or abort.
+func (b *CircuitBreaker) allow() bool {
+	if b.config.FailureThreshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.transition(CircuitHalfOpen)
+		b.trialInFlight = true
+		return true
+	case CircuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the state of the circuit breaker given the outcome of an allowed call.
+func (b *CircuitBreaker) record(success bool) {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.trialInFlight = false
+		if !success {
+			b.open()
+			return
+		}
+		b.consecutiveSuccesses++
+		if b.consecutiveSuccesses >= b.config.SuccessThreshold {
+			b.consecutiveFailures = 0
+			b.transition(CircuitClosed)
+		}
+		return
+	}
+
+	if success {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.consecutiveFailures++
+	if b.state == CircuitClosed && b.consecutiveFailures >= b.config.FailureThreshold {
+		b.open()
+	}
+}
+
+// abort releases an allowed call whose outcome is unknown (eg, cancelled by the caller) without changing the state.
+func (b *CircuitBreaker) abort() {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.trialInFlight = false
+	}
+}
+
+func (b *CircuitBreaker) Stats() CircuitBreakerStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stats := CircuitBreakerStats{
+		State:               b.state.String(),
+		ConsecutiveFailures: b.consecutiveFailures,
+	}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.consecutiveSuccesses = 0
+	b.transition(CircuitOpen)
+}
+
+func (b *CircuitBreaker) transition(to CircuitBreakerState) {
+	from := b.state
+	b.state = to
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
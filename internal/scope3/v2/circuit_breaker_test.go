@@ -0,0 +1,74 @@
+package v2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("with consecutive failures", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusBadGateway, http.StatusBadGateway)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithCircuitBreaker(scope3MockAPIServer.URL, 1*time.Hour)
+		for range 2 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.Error(t, err)
+		}
+		assert.Equal(t, CircuitOpen.String(), scope3APIClient.CircuitBreaker().Stats().State)
+
+		// Fails fast without calling scope3 server
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("with client errors", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusBadRequest, http.StatusBadRequest)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithCircuitBreaker(scope3MockAPIServer.URL, 1*time.Hour)
+		for range 2 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.Error(t, err)
+		}
+		assert.Equal(t, CircuitClosed.String(), scope3APIClient.CircuitBreaker().Stats().State)
+	})
+
+	t.Run("with recovered scope3 server", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusBadGateway, http.StatusBadGateway)
+		defer scope3MockAPIServer.Close()
+
+		var transitions []string
+		scope3APIClient := createTestScope3APIClientWithCircuitBreaker(scope3MockAPIServer.URL, 10*time.Millisecond)
+		scope3APIClient.CircuitBreaker().config.OnStateChange = func(from CircuitBreakerState, to CircuitBreakerState) {
+			transitions = append(transitions, to.String())
+		}
+		for range 2 {
+			_, _ = scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, CircuitClosed.String(), scope3APIClient.CircuitBreaker().Stats().State)
+		assert.Equal(t, []string{"open", "half-open", "closed"}, transitions)
+	})
+}
+
+func createTestScope3APIClientWithCircuitBreaker(mockServerHost string, openTimeout time.Duration) *Scope3APIClient {
+	return NewScope3APIClient(Scope3APIClientConfig{
+		Host: mockServerHost,
+		CircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      openTimeout,
+		},
+	})
+}
@@ -0,0 +1,195 @@
+package v2
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+type ConcurrencyLimitConfig struct {
+	// MaxInFlightRequests is how many calls to scope3 server are in flight at a time. The other calls wait in a queue
+	// ordered by priority. There is no limit when it is not set.
+	MaxInFlightRequests int
+	// MaxQueuedRequests is how many calls wait in the queue. Once the queue is full, the call with the lowest priority
+	// is shed with Scope3OverloadedError. There is no limit when it is not set.
+	MaxQueuedRequests int
+}
+
+// ConcurrencyLimiter bounds the calls in flight to scope3 server. Under load, the calls with the highest priority reach
+// scope3 server first, while the ones with the lowest priority are shed.
+type ConcurrencyLimiter struct {
+	config   ConcurrencyLimitConfig
+	inFlight int
+	queue    *callQueue
+	// sequence keeps the calls of the same priority in arrival order
+	sequence  uint64
+	admitted  int64
+	shed      int64
+	totalWait time.Duration
+	mutex     sync.Mutex
+}
+
+// ConcurrencyLimiterStats is the current state of the queue of calls to scope3 server.
+type ConcurrencyLimiterStats struct {
+	InFlight int `json:"inFlight"`
+	Queued   int `json:"queued"`
+	// OldestQueuedWaitInMilliseconds is how long the oldest call in the queue has been waiting
+	OldestQueuedWaitInMilliseconds int64 `json:"oldestQueuedWaitInMilliseconds"`
+	// AverageWaitInMilliseconds is how long the admitted calls waited in the queue on average
+	AverageWaitInMilliseconds float64 `json:"averageWaitInMilliseconds"`
+	Admitted                  int64   `json:"admitted"`
+	Shed                      int64   `json:"shed"`
+}
+
+type queuedCall struct {
+	priority int
+	sequence uint64
+	queuedAt time.Time
+	// admitted receives nil once the call is admitted, or Scope3OverloadedError once it is shed
+	admitted chan error
+	index    int
+}
+
+type callQueue []*queuedCall
+
+func NewConcurrencyLimiter(config ConcurrencyLimitConfig) *ConcurrencyLimiter {
+	queue := &callQueue{}
+	heap.Init(queue)
+	return &ConcurrencyLimiter{config: config, queue: queue}
+}
+
+// acquire waits until the call can be made. Every acquired call must call release once done.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, priority int) (release func(), err error) {
+	if l.config.MaxInFlightRequests <= 0 {
+		return func() {}, nil
+	}
+
+	l.mutex.Lock()
+	if l.inFlight < l.config.MaxInFlightRequests && l.queue.Len() == 0 {
+		l.inFlight++
+		l.admitted++
+		l.mutex.Unlock()
+		return l.release, nil
+	}
+	if l.config.MaxQueuedRequests > 0 && l.queue.Len() >= l.config.MaxQueuedRequests {
+		lowest := l.queue.lowest()
+		if lowest.priority >= priority {
+			l.shed++
+			l.mutex.Unlock()
+			return nil, Scope3OverloadedError{Message: "Too many calls to scope3 server queued with a higher priority"}
+		}
+		heap.Remove(l.queue, lowest.index)
+		l.shed++
+		lowest.admitted <- Scope3OverloadedError{Message: "Shed from the queue of calls to scope3 server by a higher priority"}
+	}
+	call := &queuedCall{
+		priority: priority,
+		sequence: l.sequence,
+		queuedAt: time.Now(),
+		admitted: make(chan error, 1),
+	}
+	l.sequence++
+	heap.Push(l.queue, call)
+	l.mutex.Unlock()
+
+	select {
+	case err := <-call.admitted:
+		if err != nil {
+			return nil, err
+		}
+		return l.release, nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		if call.index >= 0 {
+			heap.Remove(l.queue, call.index)
+			l.mutex.Unlock()
+		} else {
+			l.mutex.Unlock()
+			// The call was admitted or shed right when the caller gave up
+			if err := <-call.admitted; err == nil {
+				l.release()
+			}
+		}
+		return nil, Scope3ServerError{Message: "Gave up waiting in the queue of calls to scope3 server", Err: ctx.Err()}
+	}
+}
+
+// release hands the slot of a finished call over to the next call in the queue.
+func (l *ConcurrencyLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.queue.Len() == 0 {
+		l.inFlight--
+		return
+	}
+	next := heap.Pop(l.queue).(*queuedCall)
+	l.admitted++
+	l.totalWait += time.Since(next.queuedAt)
+	next.admitted <- nil
+}
+
+func (l *ConcurrencyLimiter) Stats() ConcurrencyLimiterStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := ConcurrencyLimiterStats{
+		InFlight: l.inFlight,
+		Queued:   l.queue.Len(),
+		Admitted: l.admitted,
+		Shed:     l.shed,
+	}
+	for _, call := range *l.queue {
+		stats.OldestQueuedWaitInMilliseconds = max(stats.OldestQueuedWaitInMilliseconds, time.Since(call.queuedAt).Milliseconds())
+	}
+	if l.admitted > 0 {
+		stats.AverageWaitInMilliseconds = float64(l.totalWait.Milliseconds()) / float64(l.admitted)
+	}
+	return stats
+}
+
+func (q *callQueue) Len() int { return len(*q) }
+
+// Less sorts the call with the highest priority first, then the oldest.
+func (q *callQueue) Less(i, j int) bool {
+	calls := *q
+	if calls[i].priority == calls[j].priority {
+		return calls[i].sequence < calls[j].sequence
+	}
+	return calls[i].priority > calls[j].priority
+}
+
+func (q *callQueue) Swap(i, j int) {
+	calls := *q
+	calls[i], calls[j] = calls[j], calls[i]
+	calls[i].index = i
+	calls[j].index = j
+}
+
+func (q *callQueue) Push(x interface{}) {
+	n := len(*q)
+	call := x.(*queuedCall)
+	call.index = n
+	*q = append(*q, call)
+}
+
+func (q *callQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	call := old[n-1]
+	call.index = -1 // so that a caller giving up knows the call already left the queue
+	*q = old[0 : n-1]
+	return call
+}
+
+// lowest returns the call sorted last, ie with the lowest priority, then the newest. It is only called when the queue is
+// full, which is small enough to be scanned.
+func (q *callQueue) lowest() *queuedCall {
+	calls := *q
+	lowest := calls[0]
+	for _, call := range calls[1:] {
+		if q.Less(lowest.index, call.index) {
+			lowest = call
+		}
+	}
+	return lowest
+}
@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Run("with queued calls admitted by priority", func(t *testing.T) {
+		limiter := NewConcurrencyLimiter(ConcurrencyLimitConfig{MaxInFlightRequests: 1})
+		release, err := limiter.acquire(context.Background(), 0)
+		assert.NoError(t, err)
+
+		var (
+			admitted      []int
+			admittedMutex sync.Mutex
+			wg            sync.WaitGroup
+		)
+		for i, priority := range []int{1, 3, 2} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := limiter.acquire(context.Background(), priority)
+				assert.NoError(t, err)
+				admittedMutex.Lock()
+				admitted = append(admitted, priority)
+				admittedMutex.Unlock()
+				release()
+			}()
+			waitForQueued(t, limiter, i+1)
+		}
+		assert.Equal(t, 3, limiter.Stats().Queued)
+
+		release()
+		wg.Wait()
+		assert.Equal(t, []int{3, 2, 1}, admitted)
+		stats := limiter.Stats()
+		assert.Equal(t, 0, stats.InFlight)
+		assert.Equal(t, int64(4), stats.Admitted)
+	})
+
+	t.Run("with full queue", func(t *testing.T) {
+		limiter := NewConcurrencyLimiter(ConcurrencyLimitConfig{MaxInFlightRequests: 1, MaxQueuedRequests: 1})
+		release, err := limiter.acquire(context.Background(), 0)
+		assert.NoError(t, err)
+		defer release()
+
+		lowPriorityResult := make(chan error, 1)
+		go func() {
+			_, err := limiter.acquire(context.Background(), 1)
+			lowPriorityResult <- err
+		}()
+		waitForQueued(t, limiter, 1)
+
+		// Lower or same priority than the queued call is shed right away
+		_, err = limiter.acquire(context.Background(), 1)
+		assert.ErrorAs(t, err, &Scope3OverloadedError{})
+
+		// Higher priority takes the place of the queued call
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		highPriorityResult := make(chan error, 1)
+		go func() {
+			_, err := limiter.acquire(ctx, 2)
+			highPriorityResult <- err
+		}()
+		assert.ErrorAs(t, <-lowPriorityResult, &Scope3OverloadedError{})
+		assert.ErrorIs(t, <-highPriorityResult, context.DeadlineExceeded)
+		stats := limiter.Stats()
+		assert.Equal(t, int64(2), stats.Shed)
+		assert.Equal(t, 0, stats.Queued)
+	})
+}
+
+// waitForQueued waits until the given number of calls are in the queue of the limiter.
+func waitForQueued(t *testing.T, limiter *ConcurrencyLimiter, queued int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return limiter.Stats().Queued == queued
+	}, 1*time.Second, 1*time.Millisecond)
+}
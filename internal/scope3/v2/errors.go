@@ -0,0 +1,123 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Scope3ServerError is returned when scope3 server is unavailable, ie unreachable, HTTP 5xx, or the circuit breaker is
+// open.
+type Scope3ServerError struct {
+	Message    string
+	StatusCode int
+	Err        error
+}
+
+func (e Scope3ServerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Scope3 server error: %s, caused by: %v", e.Message, e.Err)
+	}
+	return fmt.Sprintf("Scope3 server error: %s", e.Message)
+}
+
+func (e Scope3ServerError) Unwrap() error {
+	return e.Err
+}
+
+// Scope3RateLimitedError is returned when scope3 server rejects the call with HTTP 429. RetryAfter is set when scope3
+// server tells when to call again.
+type Scope3RateLimitedError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e Scope3RateLimitedError) Error() string {
+	return fmt.Sprintf("Scope3 rate limited error: %s", e.Message)
+}
+
+// Scope3OverloadedError is returned when the call is shed because too many calls to scope3 server are already queued
+// with a higher priority.
+type Scope3OverloadedError struct {
+	Message string
+}
+
+func (e Scope3OverloadedError) Error() string {
+	return fmt.Sprintf("Scope3 overloaded error: %s", e.Message)
+}
+
+// Scope3UnauthorizedError is returned when scope3 server rejects the api key with HTTP 401 or 403.
+type Scope3UnauthorizedError struct {
+	Message    string
+	StatusCode int
+}
+
+func (e Scope3UnauthorizedError) Error() string {
+	return fmt.Sprintf("Scope3 unauthorized error: %s", e.Message)
+}
+
+// Scope3BadRequestError is returned when scope3 server rejects the whole request with any other HTTP 4xx. Unlike
+// Scope3RowError, none of the rows are measured.
+type Scope3BadRequestError struct {
+	Message    string
+	StatusCode int
+	// Reason is the message field of the response body of scope3 server, if any. Unlike Message, it holds nothing but
+	// what scope3 server tells about the request, so it can be passed on to the client.
+	Reason string
+}
+
+func (e Scope3BadRequestError) Error() string {
+	return fmt.Sprintf("Scope3 bad request error: %s", e.Message)
+}
+
+// Scope3InvalidResponseError is returned when the response of scope3 server can't be understood (eg, malformed json).
+type Scope3InvalidResponseError struct {
+	Message string
+	Err     error
+}
+
+func (e Scope3InvalidResponseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Scope3 invalid response error: %s, caused by: %v", e.Message, e.Err)
+	}
+	return fmt.Sprintf("Scope3 invalid response error: %s", e.Message)
+}
+
+func (e Scope3InvalidResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Scope3RowError is the error scope3 server sets on a single row (eg, missing or < 1 impressions) while the other rows
+// of the same request succeed.
+type Scope3RowError struct {
+	Message string
+}
+
+func (e Scope3RowError) Error() string {
+	return fmt.Sprintf("Scope3 row error: %s", e.Message)
+}
+
+// newStatusError classifies the non-200 response of scope3 server into its typed error.
+func newStatusError(resp *http.Response, responseBody []byte) error {
+	message := "scope3 server returns http status " + strconv.Itoa(resp.StatusCode) +
+		" with response body: " + string(responseBody)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+		return Scope3RateLimitedError{Message: message, RetryAfter: retryAfter}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Scope3UnauthorizedError{Message: message, StatusCode: resp.StatusCode}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return Scope3ServerError{Message: message, StatusCode: resp.StatusCode}
+	case resp.StatusCode >= http.StatusBadRequest:
+		var reason struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(responseBody, &reason)
+		return Scope3BadRequestError{Message: message, StatusCode: resp.StatusCode, Reason: reason.Message}
+	default:
+		return Scope3InvalidResponseError{Message: message}
+	}
+}
@@ -0,0 +1,64 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ForwardResponse is the response of scope3 server to a forwarded call, read in full.
+type ForwardResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Forward calls any other endpoint of scope3 v2 api on behalf of the caller, with one of the api keys of the client.
+// path is relative to /v2 and may have a query (eg, /properties?limit=10). The call goes through the same limits and
+// circuit breaker as the measure api, but only the calls of safe methods (eg, GET) are retried.
+//
+// The response of scope3 server is returned as is, whatever its status. An error is only returned when there is no
+// response (eg, scope3 server is unreachable, circuit breaker is open, rate limited).
+func (s *Scope3APIClient) Forward(
+	ctx context.Context,
+	method string,
+	path string,
+	requestBody []byte,
+) (*ForwardResponse, error) {
+	release, err := s.concurrencyLimiter.acquire(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := s.apiKeyPool.checkAvailable(); err != nil {
+		return nil, err
+	}
+
+	safe := method == http.MethodGet || method == http.MethodHead
+	resp, err := s.do(ctx, method, s.baseUrl+path, requestBody, 0, safe)
+	if errors.As(err, &Scope3RateLimitedError{}) {
+		return nil, err
+	}
+	if err != nil {
+		// Maybe server is unreachable, or the caller gave up
+		return nil, Scope3ServerError{
+			Message: "Failed to call scope3 " + method + " " + path,
+			Err:     err,
+		}
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// Maybe the connection is dropped
+		return nil, Scope3ServerError{
+			Message: "Unable to read the response body of scope3 " + method + " " + path,
+			Err:     err,
+		}
+	}
+	return &ForwardResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       responseBody,
+	}, nil
+}
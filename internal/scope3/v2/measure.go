@@ -1,11 +1,13 @@
 package v2
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"net/http"
+	"sync"
 )
 
 type MeasureFilterRow struct {
@@ -14,24 +16,75 @@ type MeasureFilterRow struct {
 	InventoryId string `json:"inventoryId" validate:"required"`
 	Impressions int    `json:"impressions" validate:"required"`
 	UtcDatetime string `json:"utcDatetime" validate:"required"`
+	// Priority orders the calls waiting for scope3 server. It isn't sent to scope3 server.
+	Priority int `json:"-"`
 }
 
-type measureResponse struct {
-	Rows []measureRow `json:"rows"`
+// MeasureResult is the emissions breakdown of a single MeasureFilterRow. Err is set instead when scope3 server rejects
+// the row, or when the call of the chunk where the row belongs failed.
+type MeasureResult struct {
+	PropertyName       string
+	EmissionsBreakdown *Breakdown
+	Err                error
 }
 
-type measureRow struct {
-	// scope3 returns HTTP 200 but set the error message for field validation issues (eg, missing or < 1 impressions)
-	Error              scope3Error            `json:"error,omitempty"`
-	EmissionsBreakdown map[string]interface{} `json:"emissionsBreakdown,omitempty"`
-	Internal           map[string]interface{} `json:"internal,omitempty"`
-}
+// GetEmissionsBreakdown returns the emissions breakdown of each row in the same order as the given rows. A row rejected by
+// scope3 server doesn't fail the other rows, its MeasureResult has a Scope3RowError instead.
+//
+// Rows beyond the max rows per request are split into chunks that are sent in parallel. A failed chunk only fails its
+// rows through MeasureResult.Err, unless every chunk failed.
+func (s *Scope3APIClient) GetEmissionsBreakdown(
+	ctx context.Context,
+	rows []MeasureFilterRow,
+	options MeasureOptions,
+) ([]MeasureResult, error) {
+	if s.maxRowsPerRequest <= 0 || len(rows) <= s.maxRowsPerRequest {
+		return s.measure(ctx, rows, options)
+	}
+
+	chunkCount := (len(rows) + s.maxRowsPerRequest - 1) / s.maxRowsPerRequest
+	result := make([]MeasureResult, len(rows))
+	chunkErrors := make([]error, chunkCount)
+	concurrentRequests := make(chan struct{}, max(s.maxConcurrentRequests, 1))
+	var wg sync.WaitGroup
+	for chunk := 0; chunk < chunkCount; chunk++ {
+		start := chunk * s.maxRowsPerRequest
+		end := min(start+s.maxRowsPerRequest, len(rows))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				chunkResult []MeasureResult
+				err         error
+			)
+			select {
+			case <-ctx.Done():
+				err = Scope3ServerError{Message: "Gave up waiting to call scope3 measure api", Err: ctx.Err()}
+			case concurrentRequests <- struct{}{}:
+				chunkResult, err = s.measure(ctx, rows[start:end], options)
+				<-concurrentRequests
+			}
+			if err != nil {
+				chunkErrors[chunk] = err
+				for i := start; i < end; i++ {
+					result[i] = MeasureResult{Err: err}
+				}
+				return
+			}
+			copy(result[start:end], chunkResult)
+		}()
+	}
+	wg.Wait()
 
-type scope3Error struct {
-	Message string `json:"message"`
+	for _, err := range chunkErrors {
+		if err == nil {
+			return result, nil
+		}
+	}
+	return nil, chunkErrors[0]
 }
 
-func (s *Scope3APIClient) GetEmissionsBreakdown(rows []MeasureFilterRow) (map[string]interface{}, error) {
+func (s *Scope3APIClient) measure(ctx context.Context, rows []MeasureFilterRow, options MeasureOptions) ([]MeasureResult, error) {
 	requestBodyInBytes, err := json.Marshal(map[string]interface{}{
 		"rows": rows,
 	})
@@ -39,38 +92,97 @@ func (s *Scope3APIClient) GetEmissionsBreakdown(rows []MeasureFilterRow) (map[st
 		return nil, fmt.Errorf("unable to unmarshall request body: %w", err)
 	}
 
-	url := s.baseUrl + "/measure?includeRows=true&latest=true&fields=emissionsBreakdown"
+	url := s.baseUrl + "/measure?" + options.query()
+
+	// The call waits for its turn with the priority of its most important row
+	var priority int
+	for i, row := range rows {
+		if i == 0 || row.Priority > priority {
+			priority = row.Priority
+		}
+	}
+	release, err := s.concurrencyLimiter.acquire(ctx, priority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := s.apiKeyPool.checkAvailable(); err != nil {
+		return nil, err
+	}
 
-	resp, err := s.doPost(url, requestBodyInBytes)
+	resp, err := s.doPost(ctx, url, requestBodyInBytes, len(rows))
+	if errors.As(err, &Scope3RateLimitedError{}) {
+		return nil, err
+	}
 	if err != nil {
-		// Maybe server is unreachable
+		// Maybe server is unreachable, or the caller gave up
 		return nil, Scope3ServerError{
 			Message: "Failed to call scope3 measure api",
 			Err:     err,
 		}
 	}
+	defer resp.Body.Close()
 	responseBodyInBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read the response body: %w", err)
+		// Maybe the connection is dropped
+		return nil, Scope3ServerError{
+			Message: "Unable to read the response body of scope3 measure api",
+			Err:     err,
+		}
 	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("scope3 server returns http status " + strconv.Itoa(resp.StatusCode) +
-			" with response body: " + string(responseBodyInBytes))
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp, responseBodyInBytes)
 	}
 	var responseBody measureResponse
 	err = json.Unmarshal(responseBodyInBytes, &responseBody)
 	if err != nil {
-		return nil, fmt.Errorf("unable to unmarshall scope3 measure api response: %w", err)
+		return nil, Scope3InvalidResponseError{
+			Message: "Unable to unmarshall scope3 measure api response",
+			Err:     err,
+		}
 	}
 
-	result := make(map[string]interface{}, len(responseBody.Rows))
-	for _, row := range responseBody.Rows {
-		if row.Error.Message == "" {
-			propertyName := row.Internal["propertyName"].(string)
-			result[propertyName] = row.EmissionsBreakdown["breakdown"]
-		} else {
-			return nil, fmt.Errorf("scope3 server request error: %s", row.Error.Message)
+	// scope3 returns the rows in the same order as the request rows. It is the only way to match the result to the
+	// requested row since the same property can be requested several times with different country, channel, etc.
+	if len(responseBody.Rows) != len(rows) {
+		return nil, Scope3InvalidResponseError{
+			Message: fmt.Sprintf("scope3 measure api returns %d rows for %d requested rows", len(responseBody.Rows), len(rows)),
 		}
 	}
+	result := make([]MeasureResult, 0, len(responseBody.Rows))
+	for _, rawRow := range responseBody.Rows {
+		result = append(result, measureResultOf(rawRow))
+	}
 	return result, nil
 }
+
+// measureResultOf decodes a row of the measure api response. A row that can't be decoded only fails itself.
+func measureResultOf(rawRow json.RawMessage) MeasureResult {
+	var row measureRow
+	if err := json.Unmarshal(rawRow, &row); err != nil {
+		return MeasureResult{Err: Scope3InvalidResponseError{
+			Message: "Unable to unmarshall a row of scope3 measure api response",
+			Err:     err,
+		}}
+	}
+	switch {
+	case row.Error != nil && row.Error.Message != "":
+		return MeasureResult{Err: Scope3RowError{Message: row.Error.Message}}
+	case row.EmissionsBreakdown == nil && row.TotalEmissions == nil:
+		return MeasureResult{
+			Err: Scope3InvalidResponseError{Message: "scope3 measure api returns a row without emissions"},
+		}
+	}
+	measured := MeasureResult{EmissionsBreakdown: &Breakdown{}}
+	if row.EmissionsBreakdown != nil {
+		measured.EmissionsBreakdown = &row.EmissionsBreakdown.Breakdown
+	}
+	if row.TotalEmissions != nil && measured.EmissionsBreakdown.Totals == nil {
+		// Only requested through the totalEmissions field
+		measured.EmissionsBreakdown.Totals = &BreakdownComponent{Total: BreakdownItem{Emissions: *row.TotalEmissions}}
+	}
+	if row.Internal != nil {
+		measured.PropertyName = row.Internal.PropertyName
+	}
+	return measured
+}
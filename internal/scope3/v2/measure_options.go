@@ -0,0 +1,58 @@
+package v2
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultMeasureFields are the fields scope3 server returns on each row when MeasureOptions.Fields is not set.
+var DefaultMeasureFields = []string{"emissionsBreakdown"}
+
+// SupportedMeasureFields are the fields of scope3 measure api the emissions can be read from.
+var SupportedMeasureFields = []string{"emissionsBreakdown", "totalEmissions"}
+
+// MeasureOptions are the query params of scope3 measure api. The zero value is the default view of the emissions. The
+// rows are always included in the response, since it is the only way to get the emissions of each row.
+type MeasureOptions struct {
+	// Fields are the fields scope3 server returns on each row (eg, emissionsBreakdown, totalEmissions). Defaults to
+	// DefaultMeasureFields.
+	Fields []string
+	// Historical measures the rows with the scope3 model at the utc datetime of each row instead of the latest model
+	Historical bool
+	// Framework is the framework the emissions are broken down by. Not set means the default of scope3 server.
+	Framework string
+}
+
+// Key identifies the view of the emissions returned with these options, so that the emissions of different views are
+// kept apart (eg, in cache). It is empty for the default view.
+func (o MeasureOptions) Key() string {
+	var parts []string
+	if fields := o.fields(); !slices.Equal(fields, DefaultMeasureFields) {
+		parts = append(parts, "fields="+strings.Join(fields, ","))
+	}
+	if o.Historical {
+		parts = append(parts, "latest=false")
+	}
+	if o.Framework != "" {
+		parts = append(parts, "framework="+o.Framework)
+	}
+	return strings.Join(parts, "&")
+}
+
+func (o MeasureOptions) query() string {
+	query := "includeRows=true&latest=" + strconv.FormatBool(!o.Historical) +
+		"&fields=" + url.QueryEscape(strings.Join(o.fields(), ","))
+	if o.Framework != "" {
+		query += "&framework=" + url.QueryEscape(o.Framework)
+	}
+	return query
+}
+
+func (o MeasureOptions) fields() []string {
+	if len(o.Fields) == 0 {
+		return DefaultMeasureFields
+	}
+	return o.Fields
+}
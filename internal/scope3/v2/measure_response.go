@@ -0,0 +1,153 @@
+package v2
+
+import "encoding/json"
+
+// The models of the scope3 measure api response. Only the fields used by the app are modeled, the other fields of the
+// response are ignored, so new fields from scope3 server don't break the decoding.
+
+type measureResponse struct {
+	// The rows are decoded one by one, so that an unexpected row only fails itself
+	Rows []json.RawMessage `json:"rows"`
+}
+
+type measureRow struct {
+	// scope3 returns HTTP 200 but set the error message for field validation issues (eg, missing or < 1 impressions)
+	Error              *scope3Error        `json:"error,omitempty"`
+	TotalEmissions     *float64            `json:"totalEmissions,omitempty"`
+	EmissionsBreakdown *EmissionsBreakdown `json:"emissionsBreakdown,omitempty"`
+	Internal           *MeasureInternal    `json:"internal,omitempty"`
+}
+
+type scope3Error struct {
+	Message string `json:"message"`
+}
+
+// EmissionsBreakdown is the emissions of a row broken down by the given framework (eg, scope3).
+type EmissionsBreakdown struct {
+	Framework string    `json:"framework,omitempty"`
+	Breakdown Breakdown `json:"breakdown"`
+}
+
+// Breakdown is the emissions in gCO2e of each step of the ad supply chain, along with their totals. Compensated are
+// the emissions offset by the supply chain (eg, through carbon removal). The components not measured by scope3 server
+// are not set.
+type Breakdown struct {
+	Totals            *BreakdownComponent
+	AdSelection       *BreakdownComponent
+	Compensated       *BreakdownComponent
+	MediaDistribution *BreakdownComponent
+	CreativeDelivery  *BreakdownComponent
+	// Others are the components not modeled above (eg, a new step of the ad supply chain), passed through as is
+	Others map[string]*BreakdownComponent
+}
+
+// BreakdownComponent is the emissions of a single component of the breakdown, further broken down by source (eg,
+// platform, data).
+type BreakdownComponent struct {
+	Total     BreakdownItem            `json:"total"`
+	Breakdown map[string]BreakdownItem `json:"breakdown,omitempty"`
+}
+
+type BreakdownItem struct {
+	Emissions float64 `json:"emissions"`
+}
+
+// MeasureInternal is the metadata scope3 server resolved from the row (eg, the property of the inventory id).
+type MeasureInternal struct {
+	PropertyId   int64  `json:"propertyId,omitempty"`
+	PropertyName string `json:"propertyName,omitempty"`
+}
+
+// UnmarshalJSON decodes the components by name, where the ones not modeled are kept in Others.
+func (b *Breakdown) UnmarshalJSON(data []byte) error {
+	var components map[string]*BreakdownComponent
+	if err := json.Unmarshal(data, &components); err != nil {
+		return err
+	}
+	*b = Breakdown{}
+	for name, component := range components {
+		if field := b.component(name); field != nil {
+			*field = component
+			continue
+		}
+		if b.Others == nil {
+			b.Others = make(map[string]*BreakdownComponent)
+		}
+		b.Others[name] = component
+	}
+	return nil
+}
+
+// MarshalJSON encodes the components by name as in the scope3 measure api response, along with Others.
+func (b Breakdown) MarshalJSON() ([]byte, error) {
+	components := make(map[string]*BreakdownComponent, len(b.Others)+5)
+	for name, component := range b.Others {
+		components[name] = component
+	}
+	for name, component := range map[string]*BreakdownComponent{
+		"totals":            b.Totals,
+		"adSelection":       b.AdSelection,
+		"compensated":       b.Compensated,
+		"mediaDistribution": b.MediaDistribution,
+		"creativeDelivery":  b.CreativeDelivery,
+	} {
+		if component != nil {
+			components[name] = component
+		}
+	}
+	return json.Marshal(components)
+}
+
+// component returns the field of the modeled component with the given name, nil when not modeled.
+func (b *Breakdown) component(name string) **BreakdownComponent {
+	switch name {
+	case "totals":
+		return &b.Totals
+	case "adSelection":
+		return &b.AdSelection
+	case "compensated":
+		return &b.Compensated
+	case "mediaDistribution":
+		return &b.MediaDistribution
+	case "creativeDelivery":
+		return &b.CreativeDelivery
+	default:
+		return nil
+	}
+}
+
+// Scale returns a copy of the breakdown where every emissions is multiplied by the given factor (eg, to get the
+// emissions of another number of impressions).
+func (b *Breakdown) Scale(factor float64) *Breakdown {
+	if b == nil {
+		return nil
+	}
+	scaled := &Breakdown{
+		Totals:            b.Totals.scale(factor),
+		AdSelection:       b.AdSelection.scale(factor),
+		Compensated:       b.Compensated.scale(factor),
+		MediaDistribution: b.MediaDistribution.scale(factor),
+		CreativeDelivery:  b.CreativeDelivery.scale(factor),
+	}
+	if b.Others != nil {
+		scaled.Others = make(map[string]*BreakdownComponent, len(b.Others))
+		for name, component := range b.Others {
+			scaled.Others[name] = component.scale(factor)
+		}
+	}
+	return scaled
+}
+
+func (c *BreakdownComponent) scale(factor float64) *BreakdownComponent {
+	if c == nil {
+		return nil
+	}
+	scaled := &BreakdownComponent{Total: BreakdownItem{Emissions: c.Total.Emissions * factor}}
+	if c.Breakdown != nil {
+		scaled.Breakdown = make(map[string]BreakdownItem, len(c.Breakdown))
+		for source, item := range c.Breakdown {
+			scaled.Breakdown[source] = BreakdownItem{Emissions: item.Emissions * factor}
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,145 @@
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetEmissionsBreakdownResponse(t *testing.T) {
+	t.Run("with unknown fields", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(`{"rows":[{"totalEmissions":3,"emissionsBreakdown":{` +
+			`"framework":"scope3","breakdown":{"adSelection":{"total":{"emissions":1},"breakdown":{"platform":` +
+			`{"emissions":1,"allocation":"x"}}},"mediaDistribution":{"total":{"emissions":2}},` +
+			`"newStep":{"total":{"emissions":0.5}}}},` +
+			`"internal":{"propertyId":1,"propertyName":"nytimes.com","newMetadata":"x"}}],"newField":true}`)
+		defer scope3MockAPIServer.Close()
+
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 1).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "nytimes.com", result[0].PropertyName)
+		assert.Equal(t, &Breakdown{
+			// Filled from totalEmissions, since scope3 server doesn't set them in the breakdown
+			Totals: &BreakdownComponent{Total: BreakdownItem{Emissions: 3}},
+			AdSelection: &BreakdownComponent{
+				Total:     BreakdownItem{Emissions: 1},
+				Breakdown: map[string]BreakdownItem{"platform": {Emissions: 1}},
+			},
+			MediaDistribution: &BreakdownComponent{Total: BreakdownItem{Emissions: 2}},
+			// Not modeled, but passed through
+			Others: map[string]*BreakdownComponent{"newStep": {Total: BreakdownItem{Emissions: 0.5}}},
+		}, result[0].EmissionsBreakdown)
+	})
+
+	t.Run("without internal metadata", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(`{"rows":[{"emissionsBreakdown":{"breakdown":{}}}]}`)
+		defer scope3MockAPIServer.Close()
+
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 1).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, result[0].PropertyName)
+		assert.NotNil(t, result[0].EmissionsBreakdown)
+	})
+
+	t.Run("without emissions breakdown", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(`{"rows":[{"internal":{"propertyName":"nytimes.com"}}]}`)
+		defer scope3MockAPIServer.Close()
+
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 1).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.ErrorAs(t, result[0].Err, &Scope3InvalidResponseError{})
+	})
+
+	t.Run("with unexpected field type", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(`{"rows":[{"emissionsBreakdown":{"breakdown":` +
+			`{"adSelection":{"total":"1"}}},"internal":{"propertyName":["nytimes.com"]}},` +
+			`{"emissionsBreakdown":{"breakdown":{"adSelection":{"total":{"emissions":1}}}},` +
+			`"internal":{"propertyName":"cnn.com"}}]}`)
+		defer scope3MockAPIServer.Close()
+
+		rows := append(measureRows, MeasureFilterRow{InventoryId: "cnn.com", Impressions: 1000, UtcDatetime: "2024-10-31"})
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 2).GetEmissionsBreakdown(context.Background(), rows, MeasureOptions{})
+		// Only the unexpected row fails
+		assert.NoError(t, err)
+		assert.ErrorAs(t, result[0].Err, &Scope3InvalidResponseError{})
+		assert.NoError(t, result[1].Err)
+		assert.Equal(t, "cnn.com", result[1].PropertyName)
+	})
+
+	t.Run("json encoding", func(t *testing.T) {
+		breakdown := &Breakdown{
+			AdSelection: &BreakdownComponent{Total: BreakdownItem{Emissions: 1}},
+			Others:      map[string]*BreakdownComponent{"newStep": {Total: BreakdownItem{Emissions: 2}}},
+		}
+		encoded, err := json.Marshal(breakdown)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"adSelection":{"total":{"emissions":1}},"newStep":{"total":{"emissions":2}}}`, string(encoded))
+		var decoded Breakdown
+		assert.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Equal(t, breakdown, &decoded)
+	})
+}
+
+func TestBreakdownScale(t *testing.T) {
+	breakdown := &Breakdown{
+		Totals:      &BreakdownComponent{Total: BreakdownItem{Emissions: 3}},
+		Compensated: &BreakdownComponent{Total: BreakdownItem{Emissions: 1}},
+		AdSelection: &BreakdownComponent{
+			Total:     BreakdownItem{Emissions: 1},
+			Breakdown: map[string]BreakdownItem{"platform": {Emissions: 1}},
+		},
+		CreativeDelivery: &BreakdownComponent{Total: BreakdownItem{Emissions: 2}},
+		Others:           map[string]*BreakdownComponent{"newStep": {Total: BreakdownItem{Emissions: 1}}},
+	}
+	assert.Equal(t, &Breakdown{
+		Totals:      &BreakdownComponent{Total: BreakdownItem{Emissions: 6}},
+		Compensated: &BreakdownComponent{Total: BreakdownItem{Emissions: 2}},
+		AdSelection: &BreakdownComponent{
+			Total:     BreakdownItem{Emissions: 2},
+			Breakdown: map[string]BreakdownItem{"platform": {Emissions: 2}},
+		},
+		CreativeDelivery: &BreakdownComponent{Total: BreakdownItem{Emissions: 4}},
+		Others:           map[string]*BreakdownComponent{"newStep": {Total: BreakdownItem{Emissions: 2}}},
+	}, breakdown.Scale(2))
+	// The scaled breakdown is a copy
+	assert.Equal(t, 1.0, breakdown.AdSelection.Breakdown["platform"].Emissions)
+}
+
+func createMockHttpServerWithBody(responseBody string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(responseBody))
+	}))
+}
+
+func TestMeasureOptions(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		assert.Empty(t, MeasureOptions{}.Key())
+		assert.Empty(t, MeasureOptions{Fields: []string{"emissionsBreakdown"}}.Key())
+		assert.Equal(t, "includeRows=true&latest=true&fields=emissionsBreakdown", MeasureOptions{}.query())
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		options := MeasureOptions{
+			Fields:     []string{"totalEmissions", "emissionsBreakdown"},
+			Historical: true,
+			Framework:  "gmsf",
+		}
+		assert.Equal(t, "fields=totalEmissions,emissionsBreakdown&latest=false&framework=gmsf", options.Key())
+		assert.Equal(t, "includeRows=true&latest=false&fields=totalEmissions%2CemissionsBreakdown&framework=gmsf",
+			options.query())
+	})
+
+	t.Run("only total emissions", func(t *testing.T) {
+		scope3MockAPIServer := createMockHttpServerWithBody(`{"rows":[{"totalEmissions":3}]}`)
+		defer scope3MockAPIServer.Close()
+
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 1).GetEmissionsBreakdown(context.Background(),
+			measureRows, MeasureOptions{Fields: []string{"totalEmissions"}})
+		assert.NoError(t, err)
+		assert.Equal(t, &Breakdown{Totals: &BreakdownComponent{Total: BreakdownItem{Emissions: 3}}}, result[0].EmissionsBreakdown)
+	})
+}
@@ -0,0 +1,134 @@
+package v2
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig bounds how many requests and rows per second are sent to scope3 server, so that a burst of requests
+// doesn't exhaust the scope3 plan.
+type RateLimitConfig struct {
+	// RequestsPerSecond is how many requests per second are sent to scope3 server. Not set means no limit.
+	RequestsPerSecond float64
+	// RowsPerSecond is how many rows per second are sent to scope3 server. Not set means no limit.
+	RowsPerSecond float64
+	// Queue makes the calls over the limit wait for their turn instead of failing right away with
+	// Scope3RateLimitedError.
+	Queue bool
+	// MaxQueueWait is the longest a queued call waits for its turn. The calls that would wait longer fail right away
+	// with Scope3RateLimitedError. There is no limit when it is not set.
+	MaxQueueWait time.Duration
+}
+
+// rateLimiter is a token bucket for the requests and another one for the rows. Each bucket holds at most a second worth
+// of tokens, which is the largest burst allowed.
+type rateLimiter struct {
+	requests     *tokenBucket
+	rows         *tokenBucket
+	queue        bool
+	maxQueueWait time.Duration
+	mutex        sync.Mutex
+}
+
+type tokenBucket struct {
+	ratePerSecond float64
+	tokens        float64
+	updatedAt     time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		requests:     newTokenBucket(config.RequestsPerSecond),
+		rows:         newTokenBucket(config.RowsPerSecond),
+		queue:        config.Queue,
+		maxQueueWait: config.MaxQueueWait,
+	}
+}
+
+func newTokenBucket(ratePerSecond float64) *tokenBucket {
+	if ratePerSecond <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		ratePerSecond: ratePerSecond,
+		tokens:        ratePerSecond,
+		updatedAt:     time.Now(),
+	}
+}
+
+// wait blocks until a request of the given rows can be sent to scope3 server, or fails with Scope3RateLimitedError
+// when the calls over the limit are not queued.
+func (l *rateLimiter) wait(ctx context.Context, rows int) error {
+	if l.requests == nil && l.rows == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	wait := max(l.requests.reserveIn(now, 1), l.rows.reserveIn(now, float64(rows)))
+	if wait > 0 && (!l.queue || (l.maxQueueWait > 0 && wait > l.maxQueueWait)) {
+		l.mutex.Unlock()
+		return Scope3RateLimitedError{
+			Message:    "Too many requests to scope3 server, rate limit reached",
+			RetryAfter: wait,
+		}
+	}
+	// The tokens are taken right away, even if the call has to wait, so that the queued calls go in order
+	l.requests.take(now, 1)
+	l.rows.take(now, float64(rows))
+	l.mutex.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// Give the tokens back to the calls queued behind
+		l.mutex.Lock()
+		l.requests.giveBack(1)
+		l.rows.giveBack(float64(rows))
+		l.mutex.Unlock()
+		return Scope3ServerError{Message: "Gave up waiting for the scope3 rate limit", Err: ctx.Err()}
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.ratePerSecond, b.tokens+now.Sub(b.updatedAt).Seconds()*b.ratePerSecond)
+	b.updatedAt = now
+}
+
+// reserveIn returns how long until the given tokens are available. A request of more tokens than the bucket holds only
+// waits for a full bucket, otherwise it would never be sent.
+func (b *tokenBucket) reserveIn(now time.Time, tokens float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	missing := math.Min(tokens, b.ratePerSecond) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.ratePerSecond * float64(time.Second))
+}
+
+// take takes the tokens, which goes negative for the calls that have to wait for their turn.
+func (b *tokenBucket) take(now time.Time, tokens float64) {
+	if b == nil {
+		return
+	}
+	b.refill(now)
+	b.tokens -= tokens
+}
+
+func (b *tokenBucket) giveBack(tokens float64) {
+	if b == nil {
+		return
+	}
+	b.tokens = math.Min(b.ratePerSecond, b.tokens+tokens)
+}
@@ -0,0 +1,114 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("with requests over the limit rejected", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithRateLimit(scope3MockAPIServer.URL, RateLimitConfig{
+			RequestsPerSecond: 2,
+		})
+		for range 2 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.NoError(t, err)
+		}
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		var rateLimitedError Scope3RateLimitedError
+		assert.True(t, errors.As(err, &rateLimitedError))
+		assert.Greater(t, rateLimitedError.RetryAfter, time.Duration(0))
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("with rows over the limit queued", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil)
+		defer scope3MockAPIServer.Close()
+
+		// A single row every 100ms
+		scope3APIClient := createTestScope3APIClientWithRateLimit(scope3MockAPIServer.URL, RateLimitConfig{
+			RowsPerSecond: 10,
+			Queue:         true,
+		})
+		// Drain the burst allowed by the limiter
+		scope3APIClient.rateLimiter.rows.tokens = 0
+
+		start := time.Now()
+		for range 3 {
+			_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+			assert.NoError(t, err)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("with queue wait longer than the max queue wait", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithRateLimit(scope3MockAPIServer.URL, RateLimitConfig{
+			RequestsPerSecond: 1,
+			Queue:             true,
+			MaxQueueWait:      100 * time.Millisecond,
+		})
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		_, err = scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.ErrorAs(t, err, &Scope3RateLimitedError{})
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("with retries counted against the limit", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusServiceUnavailable,
+			http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := NewScope3APIClient(Scope3APIClientConfig{
+			Host:      scope3MockAPIServer.URL,
+			Retry:     RetryPolicy{MaxAttempts: 5, InitialBackoff: 1 * time.Millisecond, MaxBackoff: 1 * time.Millisecond},
+			RateLimit: RateLimitConfig{RequestsPerSecond: 2},
+		})
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.ErrorAs(t, err, &Scope3RateLimitedError{})
+		// The retries stop once the tokens are taken by the first attempts
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("with caller giving up while queued", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil)
+		defer scope3MockAPIServer.Close()
+
+		scope3APIClient := createTestScope3APIClientWithRateLimit(scope3MockAPIServer.URL, RateLimitConfig{
+			RequestsPerSecond: 1,
+			Queue:             true,
+		})
+		_, err := scope3APIClient.GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = scope3APIClient.GetEmissionsBreakdown(ctx, measureRows, MeasureOptions{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func createTestScope3APIClientWithRateLimit(mockServerHost string, rateLimit RateLimitConfig) *Scope3APIClient {
+	return NewScope3APIClient(Scope3APIClientConfig{
+		Host:      mockServerHost,
+		RateLimit: rateLimit,
+	})
+}
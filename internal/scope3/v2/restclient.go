@@ -2,24 +2,42 @@ package v2
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 )
 
 type Scope3APIClient struct {
-	httpClient *http.Client
-	baseUrl    string
-	apiKey     string
+	httpClient            *http.Client
+	baseUrl               string
+	apiKeyPool            *ApiKeyPool
+	maxRowsPerRequest     int
+	maxConcurrentRequests int
+	retryPolicy           RetryPolicy
+	circuitBreaker        *CircuitBreaker
+	rateLimiter           *rateLimiter
+	concurrencyLimiter    *ConcurrencyLimiter
 }
 
 type Scope3APIClientConfig struct {
-	Host               string
-	ApiKey             string
+	Host   string
+	ApiKey string
+	// ApiKeyPool spreads the calls across more keys, on top of ApiKey
+	ApiKeyPool         ApiKeyPoolConfig
 	Timeout            time.Duration
 	MaxIdleConnections int
 	IdleConnTimeout    time.Duration
+	// MaxRowsPerRequest splits the rows into chunks of this size, each sent in its own request. Not set means no limit.
+	MaxRowsPerRequest int
+	// MaxConcurrentRequests is how many chunks are sent in parallel. Defaults to 1.
+	MaxConcurrentRequests int
+	Retry                 RetryPolicy
+	CircuitBreaker        CircuitBreakerConfig
+	RateLimit             RateLimitConfig
+	ConcurrencyLimit      ConcurrencyLimitConfig
 }
 
 func NewScope3APIClient(config Scope3APIClientConfig) *Scope3APIClient {
@@ -36,33 +54,108 @@ func NewScope3APIClient(config Scope3APIClientConfig) *Scope3APIClient {
 			IdleConnTimeout: config.IdleConnTimeout,
 		},
 	}
+	if config.ApiKey != "" {
+		config.ApiKeyPool.Keys = append([]string{config.ApiKey}, config.ApiKeyPool.Keys...)
+	}
 	return &Scope3APIClient{
-		httpClient: client,
-		baseUrl:    baseUrl,
-		apiKey:     config.ApiKey,
+		httpClient:            client,
+		baseUrl:               baseUrl,
+		apiKeyPool:            NewApiKeyPool(config.ApiKeyPool),
+		maxRowsPerRequest:     config.MaxRowsPerRequest,
+		maxConcurrentRequests: config.MaxConcurrentRequests,
+		retryPolicy:           config.Retry,
+		circuitBreaker:        NewCircuitBreaker(config.CircuitBreaker),
+		rateLimiter:           newRateLimiter(config.RateLimit),
+		concurrencyLimiter:    NewConcurrencyLimiter(config.ConcurrencyLimit),
 	}
 }
 
-type Scope3ServerError struct {
-	Message string
-	Err     error
+func (s *Scope3APIClient) CircuitBreaker() *CircuitBreaker {
+	return s.circuitBreaker
+}
+
+func (s *Scope3APIClient) ApiKeyPool() *ApiKeyPool {
+	return s.apiKeyPool
+}
+
+func (s *Scope3APIClient) ConcurrencyLimiter() *ConcurrencyLimiter {
+	return s.concurrencyLimiter
+}
+
+func (s *Scope3APIClient) doPost(ctx context.Context, url string, requestBodyBytes []byte, rows int) (*http.Response, error) {
+	return s.do(ctx, http.MethodPost, url, requestBodyBytes, rows, true)
 }
 
-func (e Scope3ServerError) Error() string {
-	if e.Err != nil {
-		return fmt.Sprintf("Scope3 server error: %s, caused by: %v", e.Message, e.Err)
+// do calls scope3 server through the circuit breaker with one of the api keys. The call is only retried when it is safe
+// to make it again. Each attempt takes the tokens of the given rows from the rate limiter, so that the retries and the
+// calls with the next api key count against the rate limit too. Scope3RateLimitedError is returned as is once the rate
+// limit is reached.
+func (s *Scope3APIClient) do(
+	ctx context.Context,
+	method string,
+	url string,
+	requestBodyBytes []byte,
+	rows int,
+	retry bool,
+) (*http.Response, error) {
+	if !s.circuitBreaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	retryPolicy := s.retryPolicy
+	if !retry {
+		retryPolicy = RetryPolicy{}
+	}
+	resp, err := doWithRetry(ctx, retryPolicy, func() (*http.Response, error) {
+		for {
+			if err := s.rateLimiter.wait(ctx, rows); err != nil {
+				return nil, err
+			}
+			resp, err := s.doWithApiKey(ctx, method, url, requestBodyBytes)
+			// The rejected key is quarantined, so the call goes to the next healthy key right away. It is safe whatever
+			// the method since scope3 server rejected the call before handling it.
+			if err != nil || !isRejectedApiKey(resp.StatusCode) || !s.apiKeyPool.hasHealthyKey() {
+				return resp, err
+			}
+			// Drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	})
+	if ctx.Err() != nil || errors.As(err, &Scope3RateLimitedError{}) {
+		// The caller gave up (eg, client disconnected) or the rate limit is reached, which says nothing about scope3 server
+		s.circuitBreaker.abort()
+		return resp, err
 	}
-	return fmt.Sprintf("Scope3 server error: %s", e.Message)
+	// Only failures on scope3 server side count, since client errors (eg, bad request) would fail the same way anyway
+	s.circuitBreaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
 }
 
-func (s *Scope3APIClient) doPost(url string, requestBodyBytes []byte) (*http.Response, error) {
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBodyBytes))
+// doWithApiKey makes a single call with one of the api keys. Each call selects its key, so that the next call goes to
+// another key once scope3 server rejects one.
+func (s *Scope3APIClient) doWithApiKey(
+	ctx context.Context,
+	method string,
+	url string,
+	requestBodyBytes []byte,
+) (*http.Response, error) {
+	// The request is created on each call since its body can only be read once
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(requestBodyBytes))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+s.apiKey)
+	key := s.apiKeyPool.acquire()
+	if key != nil {
+		req.Header.Add("Authorization", "Bearer "+key.value)
+	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 
-	return s.httpClient.Do(req)
+	resp, err := s.httpClient.Do(req)
+	s.apiKeyPool.release(key, resp)
+	return resp, err
+}
+
+func isRejectedApiKey(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
 }
@@ -0,0 +1,93 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy is how the calls to scope3 server are retried on transient failures, ie connection errors, HTTP 429 and
+// HTTP 5xx. The measure api only computes emissions, so it is safe to call it again.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a call is made, including the first one. Not set means no retry.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles on each retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	// MaxBackoff is the longest wait between attempts. A call isn't retried when scope3 server asks to wait longer
+	// through the Retry-After header.
+	MaxBackoff time.Duration
+}
+
+// shouldRetry checks whether the call can be made again given the response or the error of the last attempt.
+func (p RetryPolicy) shouldRetry(attempt int, resp *http.Response, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if err != nil {
+		// The rate limit of the proxy is not a transient failure of scope3 server
+		return !errors.As(err, &Scope3RateLimitedError{})
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff returns how long to wait before the next attempt. Retry-After of the response takes precedence over the
+// exponential backoff. ok is false when scope3 server asks to wait longer than MaxBackoff.
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) (wait time.Duration, ok bool) {
+	if resp != nil {
+		if retryAfter, exists := parseRetryAfter(resp.Header.Get("Retry-After")); exists {
+			return retryAfter, retryAfter <= p.MaxBackoff
+		}
+	}
+	// Exponential backoff with full jitter so that the retries of concurrent calls are spread out
+	backoff := p.InitialBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return rand.N(backoff + 1), true
+}
+
+// parseRetryAfter parses the Retry-After header, which is either in seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// doWithRetry makes the call until it succeeds or the retry policy gives up. Responses of the failed attempts are
+// discarded, except the last one which is returned as is.
+func doWithRetry(ctx context.Context, retryPolicy RetryPolicy, do func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := do()
+		if ctx.Err() != nil || !retryPolicy.shouldRetry(attempt, resp, err) {
+			return resp, err
+		}
+		wait, ok := retryPolicy.backoff(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package v2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const measureResponseBody = `{"rows":[{"emissionsBreakdown":{"breakdown":{"adSelection":{"total":{"emissions":1.5}}}},"internal":{"propertyName":"nytimes.com"}}]}`
+
+var measureRows = []MeasureFilterRow{
+	{
+		InventoryId: "nytimes.com",
+		Impressions: 1000,
+		UtcDatetime: "2024-10-31",
+	},
+}
+
+func TestGetEmissionsBreakdownRetry(t *testing.T) {
+	t.Run("with transient server error", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusBadGateway, http.StatusServiceUnavailable)
+		defer scope3MockAPIServer.Close()
+
+		result, err := createTestScope3APIClient(scope3MockAPIServer.URL, 3).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "nytimes.com", result[0].PropertyName)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("with server error on every attempt", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusInternalServerError,
+			http.StatusInternalServerError, http.StatusInternalServerError)
+		defer scope3MockAPIServer.Close()
+
+		_, err := createTestScope3APIClient(scope3MockAPIServer.URL, 2).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("with client error", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, nil, http.StatusBadRequest)
+		defer scope3MockAPIServer.Close()
+
+		_, err := createTestScope3APIClient(scope3MockAPIServer.URL, 3).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("with connection error", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				// Close the connection without any response
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.Write([]byte(measureResponseBody))
+		}))
+		defer scope3MockAPIServer.Close()
+
+		_, err := createTestScope3APIClient(scope3MockAPIServer.URL, 3).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("with Retry-After", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, http.Header{"Retry-After": {"1"}},
+			http.StatusTooManyRequests)
+		defer scope3MockAPIServer.Close()
+
+		start := time.Now()
+		_, err := createTestScope3APIClient(scope3MockAPIServer.URL, 3).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.GreaterOrEqual(t, time.Since(start), 1*time.Second)
+	})
+
+	t.Run("with Retry-After longer than max backoff", func(t *testing.T) {
+		var calls atomic.Int32
+		scope3MockAPIServer := createMockHttpServerWithStatuses(&calls, http.Header{"Retry-After": {"60"}},
+			http.StatusTooManyRequests)
+		defer scope3MockAPIServer.Close()
+
+		_, err := createTestScope3APIClient(scope3MockAPIServer.URL, 3).GetEmissionsBreakdown(context.Background(), measureRows, MeasureOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+// createMockHttpServerWithStatuses creates a scope3 API server mock that answers with the given failed statuses, along
+// with the given headers, then succeeds on the next calls.
+func createMockHttpServerWithStatuses(calls *atomic.Int32, header http.Header, failedStatuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		if call <= len(failedStatuses) {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(failedStatuses[call-1])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(measureResponseBody))
+	}))
+}
+
+func createTestScope3APIClient(mockServerHost string, maxAttempts int) *Scope3APIClient {
+	return NewScope3APIClient(Scope3APIClientConfig{
+		Host: mockServerHost,
+		Retry: RetryPolicy{
+			MaxAttempts:    maxAttempts,
+			InitialBackoff: 1 * time.Millisecond,
+			MaxBackoff:     2 * time.Second,
+		},
+	})
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"log"
 	"os"
 	"os/signal"
 	"scope3apiproxy/api"
+	"scope3apiproxy/api/proxy"
 	"scope3apiproxy/internal"
 	"scope3apiproxy/internal/cache"
 	v2 "scope3apiproxy/internal/scope3/v2"
@@ -47,23 +49,110 @@ func main() {
 	initializeViper(logger, environment)
 
 	scope3APIClient := v2.NewScope3APIClient(v2.Scope3APIClientConfig{
-		Host:               viper.GetString("scope3.host"),
-		ApiKey:             viper.GetString("scope3.apiKey"),
-		Timeout:            time.Duration(viper.GetInt("scope3.timeoutInSeconds")) * time.Second,
-		MaxIdleConnections: viper.GetInt("scope3.maxIdleConnections"),
-		IdleConnTimeout:    time.Duration(viper.GetInt("scope3.idleConnTimeoutInSeconds")) * time.Second,
+		Host: viper.GetString("scope3.host"),
+		ApiKeyPool: v2.ApiKeyPoolConfig{
+			Keys:       scope3ApiKeys(),
+			Selection:  v2.ApiKeySelection(viper.GetString("scope3.apiKeySelection")),
+			Quarantine: time.Duration(viper.GetInt("scope3.apiKeyQuarantineInSeconds")) * time.Second,
+		},
+		Timeout:               time.Duration(viper.GetInt("scope3.timeoutInSeconds")) * time.Second,
+		MaxIdleConnections:    viper.GetInt("scope3.maxIdleConnections"),
+		IdleConnTimeout:       time.Duration(viper.GetInt("scope3.idleConnTimeoutInSeconds")) * time.Second,
+		MaxRowsPerRequest:     viper.GetInt("scope3.maxRowsPerRequest"),
+		MaxConcurrentRequests: viper.GetInt("scope3.maxConcurrentRequests"),
+		Retry: v2.RetryPolicy{
+			MaxAttempts:    viper.GetInt("scope3.retry.maxAttempts"),
+			InitialBackoff: time.Duration(viper.GetInt("scope3.retry.initialBackoffInMilliseconds")) * time.Millisecond,
+			MaxBackoff:     time.Duration(viper.GetInt("scope3.retry.maxBackoffInMilliseconds")) * time.Millisecond,
+		},
+		CircuitBreaker: v2.CircuitBreakerConfig{
+			FailureThreshold: viper.GetInt("scope3.circuitBreaker.failureThreshold"),
+			OpenTimeout:      time.Duration(viper.GetInt("scope3.circuitBreaker.openTimeoutInSeconds")) * time.Second,
+			SuccessThreshold: viper.GetInt("scope3.circuitBreaker.successThreshold"),
+			OnStateChange: func(from v2.CircuitBreakerState, to v2.CircuitBreakerState) {
+				logger.Warn("Scope3 circuit breaker state changed",
+					zap.String("from", from.String()),
+					zap.String("to", to.String()),
+				)
+			},
+		},
+		RateLimit: v2.RateLimitConfig{
+			RequestsPerSecond: viper.GetFloat64("scope3.rateLimit.requestsPerSecond"),
+			RowsPerSecond:     viper.GetFloat64("scope3.rateLimit.rowsPerSecond"),
+			Queue:             viper.GetBool("scope3.rateLimit.queue"),
+			MaxQueueWait:      time.Duration(viper.GetInt("scope3.rateLimit.maxQueueWaitInMilliseconds")) * time.Millisecond,
+		},
+		ConcurrencyLimit: v2.ConcurrencyLimitConfig{
+			MaxInFlightRequests: viper.GetInt("scope3.concurrencyLimit.maxInFlightRequests"),
+			MaxQueuedRequests:   viper.GetInt("scope3.concurrencyLimit.maxQueuedRequests"),
+		},
 	})
 
-	appCache := cache.NewCache(viper.GetInt("cache.capacity"))
+	appCache, memoryCache := newCacheStore(logger)
+	snapshotPath := viper.GetString("cache.snapshot.path")
+	if memoryCache != nil && snapshotPath != "" {
+		// Warm start so that a restart doesn't send all the traffic to scope3 server
+		loaded, err := memoryCache.LoadSnapshot(snapshotPath)
+		if err != nil {
+			logger.Warn("Unable to load the cache snapshot from "+snapshotPath, zap.Error(err))
+		} else {
+			logger.Info(fmt.Sprintf("Loaded %d cached records from %s", loaded, snapshotPath))
+		}
+	}
+
+	// Rows missing from the cache of concurrent requests are sent together to scope3 server
+	measureBatcher := v2.NewMeasureBatcher(scope3APIClient, v2.MeasureBatcherConfig{
+		Window:  time.Duration(viper.GetInt("scope3.batchWindowInMilliseconds")) * time.Millisecond,
+		MaxRows: viper.GetInt("scope3.batchMaxRows"),
+	})
 
 	emissionService := internal.NewEmissionService(
 		logger,
-		scope3APIClient,
+		measureBatcher,
 		appCache,
 		time.Duration(viper.GetInt("cache.emissionTtlInMinutes"))*time.Minute,
+		time.Duration(viper.GetInt("cache.emissionSoftTtlInMinutes"))*time.Minute,
+	)
+
+	// Background jobs are stopped on graceful shutdown
+	backgroundCtx, stopBackgroundJobs := context.WithCancel(context.Background())
+	defer stopBackgroundJobs()
+	go emissionService.RefreshStaleEmissions(
+		backgroundCtx,
+		time.Duration(viper.GetInt("cache.staleRefreshIntervalInSeconds"))*time.Second,
+	)
+	if memoryCache != nil && snapshotPath != "" {
+		go memoryCache.SaveSnapshots(
+			backgroundCtx,
+			snapshotPath,
+			time.Duration(viper.GetInt("cache.snapshot.intervalInSeconds"))*time.Second,
+			func(err error) {
+				if err != nil {
+					logger.Warn("Unable to save the cache snapshot to "+snapshotPath, zap.Error(err))
+				}
+			},
+		)
+	}
+
+	server := api.NewAPIServer(
+		viper.GetInt("port"),
+		viper.GetInt("adminPort"),
+		logger,
+		emissionService,
+		scope3APIClient,
+		appCache,
+		proxy.Config{Routes: proxyRoutes(logger)},
 	)
+	gracefulShutdownTimeout := time.Duration(viper.GetInt("gracefulShutdownTimeoutInSeconds")) * time.Second
+
+	// The api keys can be rotated without restarting the app, by updating them in the config file. The config file is
+	// only watched once the rest of the config is read, since viper doesn't support reading it while it is reloaded.
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		scope3APIClient.ApiKeyPool().SetKeys(scope3ApiKeys())
+		logger.Info("Reloaded scope3 api keys from " + event.Name)
+	})
+	viper.WatchConfig()
 
-	server := api.NewAPIServer(viper.GetInt("port"), logger, emissionService)
 	// Rune the server in a goroutine so that it won't block the graceful shutdown handling below
 	go func() {
 		server.Run()
@@ -77,9 +166,9 @@ func main() {
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
-	gracefulShutdownTimeout := time.Duration(viper.GetInt("gracefulShutdownTimeoutInSeconds")) * time.Second
 	sig := <-gracefulStop
 	logger.Debug(fmt.Sprintf("Caught sig: %+v", sig))
+	stopBackgroundJobs()
 
 	apiServerShutdownDown := make(chan bool, 1)
 	ctx, cancel := context.WithTimeout(context.Background(), gracefulShutdownTimeout)
@@ -93,6 +182,19 @@ func main() {
 		logger.Warn("HTTP APIServer did not shutdown after " + gracefulShutdownTimeout.String())
 	}
 
+	if memoryCache != nil {
+		memoryCache.Stop()
+		logger.Info(fmt.Sprintf("Reclaimed %d expired cache records in total", memoryCache.Reclaimed()))
+	}
+	// Saved once the requests are done so that the snapshot has the emissions they cached
+	if memoryCache != nil && snapshotPath != "" {
+		if err := memoryCache.SaveSnapshot(snapshotPath); err != nil {
+			logger.Warn("Unable to save the cache snapshot to "+snapshotPath, zap.Error(err))
+		} else {
+			logger.Info("Saved the cache snapshot to " + snapshotPath)
+		}
+	}
+
 	log.Println("Scope3 API application exited.")
 }
 
@@ -111,3 +213,104 @@ func initializeViper(logger *zap.Logger, environment string) {
 	viper.SetEnvKeyReplacer(replacer)
 	viper.AutomaticEnv()
 }
+
+// scope3ApiKeyPlaceholder is scope3.apiKey of the shipped config.json, which is never a valid key.
+const scope3ApiKeyPlaceholder = "set me through env var SCOPE3_APIKEY"
+
+// scope3ApiKeys returns scope3.apiKey along with scope3.apiKeys. The keys can be set through the environment variable
+// as a comma separated list. scope3.apiKey is skipped while it is still the placeholder of config.json, so that only
+// scope3.apiKeys can be set.
+func scope3ApiKeys() []string {
+	var keys []string
+	if key := viper.GetString("scope3.apiKey"); key != scope3ApiKeyPlaceholder {
+		keys = append(keys, key)
+	}
+	for _, value := range viper.GetStringSlice("scope3.apiKeys") {
+		for _, key := range strings.Split(value, ",") {
+			keys = append(keys, strings.TrimSpace(key))
+		}
+	}
+	return keys
+}
+
+// newCacheStore returns the cache backend set in cache.backend, either memory (default), redis to share the cache
+// across replicas, or tiered to keep the hot records in memory on top of redis. memoryCache is the cache in memory of
+// the app, if any.
+func newCacheStore(logger *zap.Logger) (store cache.Store, memoryCache *cache.Cache) {
+	switch backend := viper.GetString("cache.backend"); backend {
+	case "redis":
+		return newRedisStore(logger, viper.GetInt("cache.capacity")), nil
+	case "tiered":
+		memoryCache = newMemoryCache(logger, viper.GetInt("cache.l1.capacity"))
+		return cache.NewTieredStore(cache.TieredStoreConfig{
+			L1:    memoryCache,
+			L1Ttl: time.Duration(viper.GetInt("cache.l1.ttlInMinutes")) * time.Minute,
+			L2:    newRedisStore(logger, viper.GetInt("cache.l2.capacity")),
+			L2Ttl: time.Duration(viper.GetInt("cache.l2.ttlInMinutes")) * time.Minute,
+		}), memoryCache
+	case "", "memory":
+	default:
+		logger.Warn("Unknown cache backend " + backend + ", falling back to memory")
+	}
+	memoryCache = newMemoryCache(logger, viper.GetInt("cache.capacity"))
+	return memoryCache, memoryCache
+}
+
+func newMemoryCache(logger *zap.Logger, capacity int) *cache.Cache {
+	return cache.NewCache(cache.Config{
+		Capacity:    capacity,
+		GracePeriod: time.Duration(viper.GetInt("cache.gracePeriodInMinutes")) * time.Minute,
+		Shards:      viper.GetInt("cache.shards"),
+		Reaper: cache.ReaperConfig{
+			Interval:  time.Duration(viper.GetInt("cache.reaper.intervalInSeconds")) * time.Second,
+			BatchSize: viper.GetInt("cache.reaper.batchSize"),
+			OnReap: func(reclaimed int) {
+				logger.Debug(fmt.Sprintf("Reclaimed %d expired cache records", reclaimed))
+			},
+		},
+	})
+}
+
+func newRedisStore(logger *zap.Logger, capacity int) *cache.RedisStore {
+	redisStore := cache.NewRedisStore(cache.RedisStoreConfig{
+		Address:     viper.GetString("cache.redis.address"),
+		Password:    viper.GetString("cache.redis.password"),
+		DB:          viper.GetInt("cache.redis.db"),
+		KeyPrefix:   viper.GetString("cache.redis.keyPrefix"),
+		Capacity:    capacity,
+		GracePeriod: time.Duration(viper.GetInt("cache.gracePeriodInMinutes")) * time.Minute,
+		PoolSize:    viper.GetInt("cache.redis.poolSize"),
+		Timeout:     time.Duration(viper.GetInt("cache.redis.timeoutInMilliseconds")) * time.Millisecond,
+		Backoff:     time.Duration(viper.GetInt("cache.redis.backoffInMilliseconds")) * time.Millisecond,
+		OnError: func(err error) {
+			logger.Warn("Redis cache error", zap.Error(err))
+		},
+	})
+	// The app still starts so that it answers from scope3 server until redis server is reachable
+	if err := redisStore.Ping(); err != nil {
+		logger.Warn("Redis cache is unreachable on startup", zap.Error(err))
+	}
+	return redisStore
+}
+
+// proxyRoutes returns the scope3 v2 endpoints allowed through the proxy, from proxy.routes.
+func proxyRoutes(logger *zap.Logger) []proxy.Route {
+	var routeConfigs []struct {
+		Path         string
+		Methods      []string
+		TtlInSeconds int
+	}
+	if err := viper.UnmarshalKey("proxy.routes", &routeConfigs); err != nil {
+		logger.Warn("Error proxy routes config, no scope3 endpoint is allowed through the proxy", zap.Error(err))
+		return nil
+	}
+	routes := make([]proxy.Route, 0, len(routeConfigs))
+	for _, routeConfig := range routeConfigs {
+		routes = append(routes, proxy.Route{
+			Path:    routeConfig.Path,
+			Methods: routeConfig.Methods,
+			Ttl:     time.Duration(routeConfig.TtlInSeconds) * time.Second,
+		})
+	}
+	return routes
+}